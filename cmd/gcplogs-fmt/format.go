@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/evanj/gcplogs"
+)
+
+// Keys that are printed on the first line, instead of as fields.
+const (
+	severityKey = "severity"
+	timeKey     = "time"
+	messageKey  = "message"
+)
+
+// specialKeyPrefix starts the special keys that Cloud Logging extracts. It is omitted when printing
+// fields, since it makes them hard to read.
+const specialKeyPrefix = "logging.googleapis.com/"
+
+// timeFormat is the local time printed for each entry.
+const timeFormat = "2006-01-02 15:04:05.000"
+
+// ANSI terminal colors.
+const (
+	colorReset   = "\x1b[0m"
+	colorDim     = "\x1b[2m"
+	colorRed     = "\x1b[31m"
+	colorBoldRed = "\x1b[1;31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorCyan    = "\x1b[36m"
+)
+
+var severityColors = map[string]string{
+	"DEFAULT":   colorDim,
+	"DEBUG":     colorDim,
+	"INFO":      colorGreen,
+	"NOTICE":    colorCyan,
+	"WARNING":   colorYellow,
+	"ERROR":     colorRed,
+	"CRITICAL":  colorBoldRed,
+	"ALERT":     colorBoldRed,
+	"EMERGENCY": colorBoldRed,
+}
+
+// severityWidth is the length of the longest severity, so messages line up.
+const severityWidth = len("EMERGENCY")
+
+// goroutinePattern matches the first line of a Go stack trace.
+var goroutinePattern = regexp.MustCompile(`(?m)^goroutine \d+ \[[^\]]*\]:$`)
+
+// frameOffsetPattern matches the program counter offset at the end of a stack frame's file line.
+var frameOffsetPattern = regexp.MustCompile(` \+0x[0-9a-f]+$`)
+
+// formatter prints JSON log entries for people to read.
+type formatter struct {
+	out      io.Writer
+	color    bool
+	location *time.Location
+}
+
+// field is one key and its value from a JSON object, in the order they were written.
+type field struct {
+	key   string
+	value json.RawMessage
+}
+
+// format prints one line, including its newline if any. Lines that are not JSON objects are
+// printed unchanged.
+func (f *formatter) format(line []byte) error {
+	fields, err := parseObject(bytes.TrimRight(line, "\r\n"))
+	if err != nil {
+		_, err = f.out.Write(line)
+		return err
+	}
+
+	var severity, message, stack string
+	var timestamp time.Time
+	var trace string
+	var rest []field
+	for _, field := range fields {
+		s, isString := jsonString(field.value)
+		switch {
+		case field.key == severityKey && isString:
+			severity = strings.ToUpper(s)
+		case field.key == timeKey && isString:
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				rest = append(rest, field)
+				break
+			}
+			timestamp = t
+		case field.key == messageKey && isString:
+			message, stack = splitStack(s)
+		case field.key == gcplogs.TraceKey && isString:
+			trace = s
+		default:
+			rest = append(rest, field)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	if severity == "" {
+		severity = "DEFAULT"
+	}
+	f.colored(out, severityColors[severity], fmt.Sprintf("%-*s", severityWidth, severity))
+	if !timestamp.IsZero() {
+		out.WriteByte(' ')
+		out.WriteString(timestamp.In(f.location).Format(timeFormat))
+	}
+	out.WriteByte(' ')
+	writeIndented(out, message, "    ")
+	if trace != "" {
+		out.WriteByte(' ')
+		f.colored(out, colorDim, "trace="+trace)
+	}
+	out.WriteByte('\n')
+
+	for _, field := range rest {
+		out.WriteString("    ")
+		f.colored(out, colorDim, strings.TrimPrefix(field.key, specialKeyPrefix)+":")
+		out.WriteByte(' ')
+		f.writeValue(out, field)
+		out.WriteByte('\n')
+	}
+	if stack != "" {
+		out.WriteString("    ")
+		f.colored(out, colorDim, "stack:")
+		writeFrames(out, stack)
+		out.WriteByte('\n')
+	}
+
+	_, err = f.out.Write(out.Bytes())
+	return err
+}
+
+// writeValue writes a field's value: strings without quotes, source locations as file:line, and
+// other values as compact JSON.
+func (f *formatter) writeValue(out *bytes.Buffer, field field) {
+	if s, isString := jsonString(field.value); isString {
+		message, stack := splitStack(s)
+		if stack == "" {
+			writeIndented(out, s, "      ")
+			return
+		}
+		// stack_trace fields repeat the message before the stack trace
+		writeIndented(out, message, "      ")
+		writeFrames(out, stack)
+		return
+	}
+
+	if field.key == gcplogs.SourceLocationKey {
+		var location struct {
+			File     string
+			Line     int
+			Function string
+		}
+		if json.Unmarshal(field.value, &location) == nil && location.File != "" {
+			fmt.Fprintf(out, "%s:%d", location.File, location.Line)
+			if location.Function != "" {
+				out.WriteString(" " + location.Function)
+			}
+			return
+		}
+	}
+
+	err := json.Compact(out, field.value)
+	if err != nil {
+		out.Write(field.value)
+	}
+}
+
+func (f *formatter) colored(out *bytes.Buffer, color string, s string) {
+	if !f.color || color == "" {
+		out.WriteString(s)
+		return
+	}
+	out.WriteString(color)
+	out.WriteString(s)
+	out.WriteString(colorReset)
+}
+
+// parseObject returns the fields of a JSON object in order. It returns an error if line is not
+// exactly one JSON object.
+func parseObject(line []byte) ([]field, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('{') {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+	var fields []field
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		err = decoder.Decode(&value)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field{key, value})
+	}
+	_, err = decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON object")
+	}
+	return fields, nil
+}
+
+func jsonString(value json.RawMessage) (string, bool) {
+	var s string
+	err := json.Unmarshal(value, &s)
+	return s, err == nil
+}
+
+// splitStack returns the message before a Go stack trace in s, and the stack trace, which is
+// empty if s does not contain one.
+func splitStack(s string) (string, string) {
+	location := goroutinePattern.FindStringIndex(s)
+	if location == nil {
+		return s, ""
+	}
+	return strings.TrimRight(s[:location[0]], "\n"), s[location[0]:]
+}
+
+// writeIndented writes s, indenting lines after the first.
+func writeIndented(out *bytes.Buffer, s string, indent string) {
+	out.WriteString(strings.ReplaceAll(s, "\n", "\n"+indent))
+}
+
+// writeFrames writes a Go stack trace on new lines, with one line per frame: the function, then the
+// file and line. The goroutine line is omitted, and other lines are written unchanged.
+func writeFrames(out *bytes.Buffer, stack string) {
+	lines := strings.Split(strings.TrimRight(stack, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if goroutinePattern.MatchString(line) {
+			continue
+		}
+		if i+1 < len(lines) && !strings.HasPrefix(line, "\t") &&
+			strings.HasPrefix(lines[i+1], "\t") {
+			function := strings.TrimSuffix(line, "(...)")
+			file := frameOffsetPattern.ReplaceAllString(strings.TrimPrefix(lines[i+1], "\t"), "")
+			fmt.Fprintf(out, "\n      %s %s", function, file)
+			i++
+			continue
+		}
+		out.WriteString("\n      " + line)
+	}
+}
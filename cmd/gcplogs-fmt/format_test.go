@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"not json\n", "not json\n"},
+		{"[1,2]\r\n", "[1,2]\r\n"},
+		{`{"message":"a"} {"message":"b"}` + "\n", `{"message":"a"} {"message":"b"}` + "\n"},
+		{"", ""},
+		{
+			`{"severity":"INFO","time":"2019-02-24T15:58:10.864987654Z","message":"hello",` +
+				`"logging.googleapis.com/trace":"projects/p/traces/t","n":1,"s":"a\nb",` +
+				`"logging.googleapis.com/labels":{"k": "v"},` +
+				`"logging.googleapis.com/sourceLocation":{"file":"main.go","line":42}}` + "\n",
+			"INFO      2019-02-24 10:58:10.864 hello trace=projects/p/traces/t\n" +
+				"    n: 1\n" +
+				"    s: a\n" +
+				"      b\n" +
+				"    labels: {\"k\":\"v\"}\n" +
+				"    sourceLocation: main.go:42\n",
+		},
+		{`{"message":"no severity","time":"bad"}`,
+			"DEFAULT   no severity\n    time: bad\n"},
+		{
+			`{"severity":"ERROR","message":"failed\n\ngoroutine 1 [running]:\nmain.f(...)\n` +
+				`\t/src/main.go:12\nmain.main()\n\t/src/main.go:20 +0x39","error":"boom"}`,
+			"ERROR     failed\n" +
+				"    error: boom\n" +
+				"    stack:\n" +
+				"      main.f /src/main.go:12\n" +
+				"      main.main() /src/main.go:20\n",
+		},
+		{
+			`{"severity":"ERROR","message":"failed",` +
+				`"stack_trace":"failed\n\ngoroutine 1 [running]:\nmain.f(...)\n\t/src/main.go:12"}`,
+			"ERROR     failed\n" +
+				"    stack_trace: failed\n" +
+				"      main.f /src/main.go:12\n",
+		},
+	}
+
+	location := time.FixedZone("EST", -5*60*60)
+	for i, test := range tests {
+		out := &bytes.Buffer{}
+		f := &formatter{out: out, location: location}
+		err := f.format([]byte(test.line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.expected {
+			t.Errorf("%d: format(%#v)=\n%s\nexpected:\n%s", i, test.line, out.String(), test.expected)
+		}
+	}
+}
+
+func TestFormatColor(t *testing.T) {
+	out := &bytes.Buffer{}
+	f := &formatter{out: out, color: true, location: time.UTC}
+	err := f.formatAll(strings.NewReader(`{"severity":"WARNING","message":"m","k":"v"}` +
+		"\nplain\n" + `{"severity":"ERROR","message":"no newline"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = colorYellow + "WARNING  " + colorReset + " m\n" +
+		"    " + colorDim + "k:" + colorReset + " v\n" +
+		"plain\n" +
+		colorRed + "ERROR    " + colorReset + " no newline\n"
+	if out.String() != expected {
+		t.Errorf("output=%#v; expected %#v", out.String(), expected)
+	}
+}
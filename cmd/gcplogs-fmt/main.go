@@ -0,0 +1,73 @@
+// Command gcplogs-fmt reads JSON log lines written for Cloud Logging, such as by
+// gcpzap.NewProduction, and prints them for people to read: the colored severity, local time,
+// message and trace on one line, with the other fields indented below. Go stack traces are printed
+// with one line per frame. Lines that are not JSON objects are printed unchanged.
+//
+// Usage:
+//
+//	go run ./cmd/server 2>&1 | gcplogs-fmt
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/evanj/gcplogs/internal/terminal"
+)
+
+func main() {
+	color := flag.String("color", "auto",
+		"color the output: always, never, or auto to color when writing to a terminal")
+	utc := flag.Bool("utc", false, "print times in UTC instead of local time")
+	flag.Parse()
+	if flag.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: gcplogs-fmt [flags] < input")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	f := &formatter{out: os.Stdout, location: time.Local}
+	switch *color {
+	case "always":
+		f.color = true
+	case "never":
+	case "auto":
+		f.color = terminal.IsTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+	default:
+		fmt.Fprintf(os.Stderr, "gcplogs-fmt: invalid -color=%#v\n", *color)
+		os.Exit(2)
+	}
+	if *utc {
+		f.location = time.UTC
+	}
+
+	err := f.formatAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gcplogs-fmt:", err)
+		os.Exit(1)
+	}
+}
+
+// formatAll formats each line read from r.
+func (f *formatter) formatAll(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			formatErr := f.format(line)
+			if formatErr != nil {
+				return formatErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
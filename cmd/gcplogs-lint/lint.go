@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evanj/gcplogs"
+)
+
+// defaultMaxEntrySize is Cloud Logging's limit for one entry. Larger entries are dropped. See:
+// https://cloud.google.com/logging/quotas#log-limits
+const defaultMaxEntrySize = 256 * 1024
+
+// The severities Cloud Logging understands, and their numeric values. See:
+// https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#LogSeverity
+var severities = map[string]int{
+	"DEFAULT":   0,
+	"DEBUG":     100,
+	"INFO":      200,
+	"NOTICE":    300,
+	"WARNING":   400,
+	"ERROR":     500,
+	"CRITICAL":  600,
+	"ALERT":     700,
+	"EMERGENCY": 800,
+}
+
+// severityMistakes are common severities from other logging libraries, with the correct name.
+var severityMistakes = map[string]string{
+	"WARN":  "WARNING",
+	"ERR":   "ERROR",
+	"FATAL": "CRITICAL",
+	"PANIC": "ALERT",
+	"TRACE": "DEBUG",
+}
+
+// unixNanosPattern matches Unix seconds "." nanoseconds, which Cloud Logging does not parse.
+var unixNanosPattern = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+var spanIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// lineReport is what Cloud Logging extracts from one line, and any problems.
+type lineReport struct {
+	// fields are the extracted special fields, as name=value strings.
+	fields   []string
+	problems []string
+}
+
+func (r *lineReport) add(name string, value string) {
+	r.fields = append(r.fields, name+"="+value)
+}
+
+func (r *lineReport) problem(format string, args ...any) {
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+// lintLine returns what Cloud Logging extracts from line, which must not include the newline.
+func lintLine(line []byte, maxEntrySize int) *lineReport {
+	r := &lineReport{}
+	if len(line) > maxEntrySize {
+		r.problem("entry is %d bytes; Cloud Logging drops entries larger than %d bytes",
+			len(line), maxEntrySize)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var payload map[string]any
+	err := decoder.Decode(&payload)
+	// null decodes to a nil map without an error
+	if err != nil || payload == nil || decoder.More() {
+		r.problem("not a JSON object: written as textPayload with severity DEFAULT")
+		return r
+	}
+
+	lintSeverity(r, payload)
+	lintTimestamp(r, payload)
+	lintTrace(r, payload)
+	lintLabels(r, payload)
+	lintSourceLocation(r, payload)
+	lintHTTPRequest(r, payload)
+	if _, ok := payload["message"]; !ok {
+		if _, ok := payload["log"]; ok {
+			r.problem(`"log" is not used as the message; use "message"`)
+		}
+	}
+	return r
+}
+
+func lintSeverity(r *lineReport, payload map[string]any) {
+	value, ok := payload["severity"]
+	if !ok {
+		for _, key := range []string{"level", "Severity", "severityText"} {
+			if _, ok := payload[key]; ok {
+				r.problem(`%#v is not used as the severity; use "severity"`, key)
+			}
+		}
+		r.add("severity", "DEFAULT")
+		return
+	}
+
+	switch v := value.(type) {
+	case string:
+		upper := strings.ToUpper(v)
+		if _, ok := severities[upper]; ok {
+			r.add("severity", upper)
+			return
+		}
+		if correct, ok := severityMistakes[upper]; ok {
+			r.problem("unknown severity %#v: use %#v", v, correct)
+		} else {
+			r.problem("unknown severity %#v", v)
+		}
+	case json.Number:
+		n, err := v.Int64()
+		for name, level := range severities {
+			if err == nil && int64(level) == n {
+				r.add("severity", name)
+				return
+			}
+		}
+		r.problem("unknown numeric severity %s", v)
+	default:
+		r.problem("severity must be a string: %s", formatJSON(value))
+	}
+	r.add("severity", "DEFAULT")
+}
+
+func lintTimestamp(r *lineReport, payload map[string]any) {
+	if value, ok := payload["timestamp"]; ok {
+		object, isObject := value.(map[string]any)
+		seconds, secondsOK := jsonInt(object["seconds"])
+		nanos, nanosOK := jsonInt(object["nanos"])
+		if !isObject || !secondsOK || (!nanosOK && object["nanos"] != nil) {
+			r.problem(`"timestamp" must be an object {"seconds":S,"nanos":N}; strings and numbers `+
+				`do not work: %s`, formatJSON(value))
+			return
+		}
+		r.add("timestamp", formatTime(time.Unix(seconds, nanos)))
+		return
+	}
+
+	if value, ok := payload["timestampSeconds"]; ok {
+		seconds, secondsOK := jsonInt(value)
+		nanos, nanosOK := jsonInt(payload["timestampNanos"])
+		if !secondsOK || (!nanosOK && payload["timestampNanos"] != nil) {
+			r.problem(`"timestampSeconds" and "timestampNanos" must be integers`)
+			return
+		}
+		r.add("timestamp", formatTime(time.Unix(seconds, nanos)))
+		return
+	}
+
+	value, ok := payload["time"]
+	if !ok {
+		r.add("timestamp", "(time received)")
+		return
+	}
+	s, isString := value.(string)
+	if number, isNumber := value.(json.Number); isNumber {
+		s = number.String()
+	}
+	if unixNanosPattern.MatchString(s) {
+		r.problem(`"time" %s is Unix seconds.nanos, which does not work; use RFC3339`, s)
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if !isString || err != nil {
+		r.problem(`"time" must be an RFC3339 string: %s`, formatJSON(value))
+		return
+	}
+	r.add("timestamp", formatTime(t))
+}
+
+func lintTrace(r *lineReport, payload map[string]any) {
+	if value, ok := payload[gcplogs.TraceKey]; ok {
+		trace, _ := value.(string)
+		r.add("trace", formatJSON(value))
+		parts := strings.Split(trace, "/")
+		if len(parts) != 4 || parts[0] != "projects" || parts[2] != "traces" || parts[3] == "" {
+			r.problem("trace must be projects/PROJECT_ID/traces/TRACE_ID to link to Cloud Trace: %s",
+				formatJSON(value))
+		}
+	}
+	if value, ok := payload[gcplogs.SpanIDKey]; ok {
+		spanID, _ := value.(string)
+		r.add("spanId", formatJSON(value))
+		if !spanIDPattern.MatchString(spanID) {
+			r.problem("spanId must be 16 lowercase hex characters: %s", formatJSON(value))
+		}
+	}
+	if value, ok := payload[gcplogs.TraceSampledKey]; ok {
+		r.add("trace_sampled", formatJSON(value))
+		if _, isBool := value.(bool); !isBool {
+			r.problem("trace_sampled must be a boolean: %s", formatJSON(value))
+		}
+	}
+}
+
+func lintLabels(r *lineReport, payload map[string]any) {
+	value, ok := payload[gcplogs.LabelsKey]
+	if !ok {
+		return
+	}
+	r.add("labels", formatJSON(value))
+	labels, isObject := value.(map[string]any)
+	if !isObject {
+		r.problem("labels must be an object")
+		return
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, isString := labels[key].(string); !isString {
+			r.problem("label %#v must be a string: %s", key, formatJSON(labels[key]))
+		}
+	}
+}
+
+func lintSourceLocation(r *lineReport, payload map[string]any) {
+	value, ok := payload[gcplogs.SourceLocationKey]
+	if !ok {
+		return
+	}
+	r.add("sourceLocation", formatJSON(value))
+	location, isObject := value.(map[string]any)
+	if !isObject {
+		r.problem("sourceLocation must be an object")
+		return
+	}
+	if _, isString := location["file"].(string); !isString {
+		r.problem("sourceLocation.file must be a string")
+	}
+	if line, ok := location["line"]; ok {
+		if _, isInt := jsonInt(line); !isInt {
+			r.problem("sourceLocation.line must be an integer: %s", formatJSON(line))
+		}
+	}
+}
+
+// httpRequestKeys are the fields of HttpRequest. See:
+// https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#HttpRequest
+var httpRequestKeys = map[string]bool{
+	"requestMethod": true, "requestUrl": true, "requestSize": true, "status": true,
+	"responseSize": true, "userAgent": true, "remoteIp": true, "serverIp": true, "referer": true,
+	"latency": true, "cacheLookup": true, "cacheHit": true, "cacheValidatedWithOriginServer": true,
+	"cacheFillBytes": true, "protocol": true,
+}
+
+func lintHTTPRequest(r *lineReport, payload map[string]any) {
+	value, ok := payload[gcplogs.HTTPRequestKey]
+	if !ok {
+		return
+	}
+	r.add("httpRequest", formatJSON(value))
+	request, isObject := value.(map[string]any)
+	if !isObject {
+		r.problem("httpRequest must be an object")
+		return
+	}
+	keys := make([]string, 0, len(request))
+	for key := range request {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !httpRequestKeys[key] {
+			r.problem("httpRequest.%s is not an HttpRequest field", key)
+		}
+	}
+	if latency, ok := request["latency"]; ok {
+		s, _ := latency.(string)
+		if !strings.HasSuffix(s, "s") {
+			r.problem(`httpRequest.latency must be a duration string like "0.5s": %s`, formatJSON(latency))
+		}
+	}
+}
+
+// jsonInt returns value as an integer, if it is an integer JSON number.
+func jsonInt(value any) (int64, bool) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	n, err := number.Int64()
+	return n, err == nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func formatJSON(value any) string {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(out)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLintLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		fields   string
+		problems []string
+	}{
+		{
+			`{"severity":"ERROR","time":"2019-02-24T15:58:10.864987654Z","message":"m",` +
+				`"logging.googleapis.com/trace":"projects/p/traces/t",` +
+				`"logging.googleapis.com/spanId":"00f067aa0ba902b7",` +
+				`"logging.googleapis.com/trace_sampled":true,` +
+				`"logging.googleapis.com/labels":{"k":"v"},` +
+				`"logging.googleapis.com/sourceLocation":{"file":"main.go","line":42}}`,
+			`severity=ERROR timestamp=2019-02-24T15:58:10.864987654Z ` +
+				`trace="projects/p/traces/t" spanId="00f067aa0ba902b7" trace_sampled=true ` +
+				`labels={"k":"v"} sourceLocation={"file":"main.go","line":42}`,
+			nil,
+		},
+		{
+			`{"severity":"warning","timestamp":{"seconds":1551023890,"nanos":858987654}}`,
+			`severity=WARNING timestamp=2019-02-24T15:58:10.858987654Z`,
+			nil,
+		},
+		{
+			`{"severity":300,"timestampSeconds":1551023890,"timestampNanos":862987654}`,
+			`severity=NOTICE timestamp=2019-02-24T15:58:10.862987654Z`,
+			nil,
+		},
+		{`{"message":"m"}`, `severity=DEFAULT timestamp=(time received)`, nil},
+		{`not json`, ``, []string{"not a JSON object"}},
+		{`null`, ``, []string{"not a JSON object"}},
+		{`{"severity":"warn","level":"x"}`, `severity=DEFAULT timestamp=(time received)`,
+			[]string{`unknown severity "warn": use "WARNING"`}},
+		{`{"level":"info"}`, `severity=DEFAULT timestamp=(time received)`,
+			[]string{`"level" is not used as the severity`}},
+		{`{"time":"1551023890.858987654"}`, `severity=DEFAULT`,
+			[]string{`"time" 1551023890.858987654 is Unix seconds.nanos`}},
+		{`{"time":1551023890.858987654}`, `severity=DEFAULT`,
+			[]string{`"time" 1551023890.858987654 is Unix seconds.nanos`}},
+		{`{"timestamp":"2019-02-24T15:58:10.864987654Z"}`, `severity=DEFAULT`,
+			[]string{`"timestamp" must be an object`}},
+		{`{"timestamp":{}}`, `severity=DEFAULT`, []string{`"timestamp" must be an object`}},
+		{`{"timestamp":{"nanos":1}}`, `severity=DEFAULT`, []string{`"timestamp" must be an object`}},
+		{`{"logging.googleapis.com/trace":"105445aa7843bc8bf206b120001000"}`,
+			`severity=DEFAULT timestamp=(time received) trace="105445aa7843bc8bf206b120001000"`,
+			[]string{"trace must be projects/PROJECT_ID/traces/TRACE_ID"}},
+		{`{"logging.googleapis.com/labels":{"n":42}}`,
+			`severity=DEFAULT timestamp=(time received) labels={"n":42}`,
+			[]string{`label "n" must be a string`}},
+		{`{"httpRequest":{"requestMethod":"GET","latency":0.5,"method":"GET"}}`,
+			`severity=DEFAULT timestamp=(time received) ` +
+				`httpRequest={"latency":0.5,"method":"GET","requestMethod":"GET"}`,
+			[]string{"httpRequest.method is not an HttpRequest field", "httpRequest.latency must be"}},
+	}
+
+	for i, test := range tests {
+		report := lintLine([]byte(test.line), defaultMaxEntrySize)
+		fields := strings.Join(report.fields, " ")
+		if !strings.HasPrefix(fields, test.fields) {
+			t.Errorf("%d: fields=%#v; expected %#v", i, fields, test.fields)
+		}
+		if len(report.problems) != len(test.problems) {
+			t.Errorf("%d: problems=%#v; expected %#v", i, report.problems, test.problems)
+			continue
+		}
+		for j, problem := range test.problems {
+			if !strings.Contains(report.problems[j], problem) {
+				t.Errorf("%d: problem %#v must contain %#v", i, report.problems[j], problem)
+			}
+		}
+	}
+
+	// oversized entries
+	report := lintLine([]byte(`{"message":"`+strings.Repeat("x", 100)+`"}`), 100)
+	if len(report.problems) != 1 || !strings.Contains(report.problems[0], "larger than 100 bytes") {
+		t.Errorf("must report oversized entries: %#v", report.problems)
+	}
+}
+
+func TestLinter(t *testing.T) {
+	input := `{"severity":"INFO"}` + "\n\n" + `{"severity":"bad"}` + "\r\n" + `{"severity":"DEBUG"}`
+	out := &bytes.Buffer{}
+	l := &linter{out: out, maxEntrySize: defaultMaxEntrySize, problemsOnly: true}
+	err := l.lint("input", strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.lines != 3 || l.problems != 1 {
+		t.Errorf("lines=%d problems=%d; expected 3 and 1", l.lines, l.problems)
+	}
+	const expected = "input:3: severity=DEFAULT timestamp=(time received)\n" +
+		"  problem: unknown severity \"bad\"\n"
+	if out.String() != expected {
+		t.Errorf("output=%#v; expected %#v", out.String(), expected)
+	}
+}
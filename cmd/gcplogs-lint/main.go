@@ -0,0 +1,97 @@
+// Command gcplogs-lint reads JSON log lines and reports how Cloud Logging will interpret each one:
+// the severity, timestamp, trace, labels, source location and HTTP request it extracts. It reports
+// common mistakes, such as time formats that do not work, and exits with status 1 if it finds any,
+// so it can check log output in CI.
+//
+// Usage:
+//
+//	gcplogs-lint [flags] [file ...]
+//
+// It reads standard input if no files are given.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	maxEntrySize := flag.Int("max-entry-size", defaultMaxEntrySize,
+		"maximum size of an entry in bytes")
+	problemsOnly := flag.Bool("problems-only", false, "only print lines with problems")
+	flag.Parse()
+
+	inputs := flag.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+	linter := &linter{out: os.Stdout, maxEntrySize: *maxEntrySize, problemsOnly: *problemsOnly}
+	for _, input := range inputs {
+		err := linter.lintInput(input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gcplogs-lint:", err)
+			os.Exit(2)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "%d lines; %d problems\n", linter.lines, linter.problems)
+	if linter.problems > 0 {
+		os.Exit(1)
+	}
+}
+
+type linter struct {
+	out          io.Writer
+	maxEntrySize int
+	problemsOnly bool
+
+	lines    int
+	problems int
+}
+
+// lintInput lints the file at path, or standard input if path is "-".
+func (l *linter) lintInput(path string) error {
+	if path == "-" {
+		return l.lint("stdin", os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return l.lint(path, f)
+}
+
+// lint prints the report for each line read from r.
+func (l *linter) lint(name string, r io.Reader) error {
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			continue
+		}
+
+		report := lintLine(line, l.maxEntrySize)
+		l.lines++
+		l.problems += len(report.problems)
+		if l.problemsOnly && len(report.problems) == 0 {
+			continue
+		}
+		fmt.Fprintf(l.out, "%s:%d: %s\n", name, lineNumber, strings.Join(report.fields, " "))
+		for _, problem := range report.problems {
+			fmt.Fprintf(l.out, "  problem: %s\n", problem)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package gcplogs
+
+import (
+	"context"
+	"net/http"
+)
+
+type traceContextKey struct{}
+
+// ContextWithTrace returns a copy of ctx that carries traceContext. Logging code that only has a
+// context.Context, including goroutines started from a request, can get it with TraceFromContext.
+func ContextWithTrace(ctx context.Context, traceContext TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, traceContext)
+}
+
+// TraceFromContext returns the TraceContext stored in ctx by ContextWithTrace, or the zero
+// TraceContext if ctx does not have one.
+func TraceFromContext(ctx context.Context) TraceContext {
+	traceContext, _ := ctx.Value(traceContextKey{}).(TraceContext)
+	return traceContext
+}
+
+// ContextFromRequest returns r's context with the TraceContext from r's headers, if it is set.
+func (t *Tracer) ContextFromRequest(r *http.Request) context.Context {
+	traceContext := t.TraceContextFromRequest(r)
+	if traceContext.Trace == "" {
+		return r.Context()
+	}
+	return ContextWithTrace(r.Context(), traceContext)
+}
+
+// Middleware returns an http.Handler that stores the TraceContext from each request in the
+// request's context, then calls next. Handlers can retrieve it with TraceFromContext(r.Context()).
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(t.ContextFromRequest(r)))
+	})
+}
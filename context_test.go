@@ -0,0 +1,53 @@
+package gcplogs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceFromContext(t *testing.T) {
+	ctx := context.Background()
+	if TraceFromContext(ctx) != (TraceContext{}) {
+		t.Error("TraceFromContext must return the zero TraceContext if ctx does not have one")
+	}
+
+	traceContext := TraceContext{"projects/p/traces/t", "000000000000007b", true, true}
+	ctx = ContextWithTrace(ctx, traceContext)
+	if TraceFromContext(ctx) != traceContext {
+		t.Errorf("TraceFromContext()=%#v; expected %#v", TraceFromContext(ctx), traceContext)
+	}
+
+	// derived contexts, such as those passed to goroutines, still have the trace
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if TraceFromContext(childCtx) != traceContext {
+		t.Errorf("TraceFromContext(child)=%#v; expected %#v", TraceFromContext(childCtx), traceContext)
+	}
+}
+
+func TestTracerMiddleware(t *testing.T) {
+	tracer := &Tracer{ProjectID: "test_id"}
+	var handlerTrace TraceContext
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerTrace = TraceFromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(TraceHeader, "105445aa7843bc8bf206b120001000/123;o=1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	expected := TraceContext{"projects/test_id/traces/105445aa7843bc8bf206b120001000", "000000000000007b", true, true}
+	if handlerTrace != expected {
+		t.Errorf("handler trace=%#v; expected %#v", handlerTrace, expected)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if handlerTrace != (TraceContext{}) {
+		t.Errorf("handler trace=%#v; expected the zero TraceContext", handlerTrace)
+	}
+	if tracer.ContextFromRequest(r) != r.Context() {
+		t.Error("ContextFromRequest must return the request's context without a trace")
+	}
+}
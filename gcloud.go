@@ -0,0 +1,140 @@
+package gcplogs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Environment variables that configure gcloud. See `gcloud topic configurations`.
+const gcloudConfigDirEnvVar = "CLOUDSDK_CONFIG"
+const gcloudActiveConfigEnvVar = "CLOUDSDK_ACTIVE_CONFIG_NAME"
+const gcloudProjectEnvVar = "CLOUDSDK_CORE_PROJECT"
+
+const gcloudDefaultConfigName = "default"
+
+// Returns the project configured for gcloud. This reads gcloud's configuration files, since
+// executing gcloud is slow and it is usually not installed in containers. It only executes gcloud
+// if it cannot find the configuration directory. This is similar to the Java implementation:
+// https://github.com/googleapis/google-cloud-java/blob/master/google-cloud-clients/google-cloud-core/src/main/java/com/google/cloud/ServiceOptions.java
+func gcloudConfigProjectID(ctx context.Context) (string, error) {
+	projectID := os.Getenv(gcloudProjectEnvVar)
+	if projectID != "" {
+		return projectID, nil
+	}
+
+	configDir, err := gcloudConfigDir()
+	if err == nil {
+		_, err = os.Stat(configDir)
+	}
+	if err != nil {
+		// gcloud might be configured in a way we don't understand: ask it
+		return gcloudExecProjectID(ctx)
+	}
+	return gcloudConfigFileProjectID(configDir)
+}
+
+// Returns the gcloud configuration directory, which may not exist.
+func gcloudConfigDir() (string, error) {
+	configDir := os.Getenv(gcloudConfigDirEnvVar)
+	if configDir != "" {
+		return configDir, nil
+	}
+
+	if runtime.GOOS == "windows" {
+		appData := os.Getenv("APPDATA")
+		if appData == "" {
+			return "", fmt.Errorf("gcloud config: APPDATA is not set")
+		}
+		return filepath.Join(appData, "gcloud"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("gcloud config: %w", err)
+	}
+	return filepath.Join(home, ".config", "gcloud"), nil
+}
+
+// Returns the project from the active configuration in configDir, or the empty string if it does
+// not set one.
+func gcloudConfigFileProjectID(configDir string) (string, error) {
+	configName := os.Getenv(gcloudActiveConfigEnvVar)
+	if configName == "" {
+		activeConfig, err := os.ReadFile(filepath.Join(configDir, "active_config"))
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("gcloud config: %w", err)
+		}
+		configName = string(bytes.TrimSpace(activeConfig))
+	}
+	if configName == "" {
+		configName = gcloudDefaultConfigName
+	}
+
+	configPath := filepath.Join(configDir, "configurations", "config_"+configName)
+	f, err := os.Open(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// gcloud has not been configured
+			return "", nil
+		}
+		return "", fmt.Errorf("gcloud config: %w", err)
+	}
+	defer f.Close()
+
+	projectID, err := parseINIValue(f, "core", "project")
+	if err != nil {
+		return "", fmt.Errorf("gcloud config %s: %w", configPath, err)
+	}
+	return projectID, nil
+}
+
+// Returns the value of key in section from an INI file, in the format read by Python's
+// configparser, or the empty string if it is not set. Keys are not case sensitive.
+func parseINIValue(r io.Reader, section string, key string) (string, error) {
+	currentSection := ""
+	value := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			currentSection = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if currentSection != section {
+			continue
+		}
+
+		separatorIndex := strings.IndexAny(line, "=:")
+		if separatorIndex < 0 {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(line[:separatorIndex]), key) {
+			// later values replace earlier ones
+			value = strings.TrimSpace(line[separatorIndex+1:])
+		}
+	}
+	return value, scanner.Err()
+}
+
+// Returns the project by executing gcloud, which is slow.
+func gcloudExecProjectID(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "gcloud", "config", "get-value", "core/project")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to get default project from gcloud: %s",
+			err.Error())
+	}
+	// out contains the value with a new line
+	projectID := string(bytes.TrimSpace(out))
+	return projectID, nil
+}
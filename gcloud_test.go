@@ -0,0 +1,92 @@
+package gcplogs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGcloudConfigProjectID(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv(gcloudConfigDirEnvVar, configDir)
+	t.Setenv(gcloudActiveConfigEnvVar, "")
+	t.Setenv(gcloudProjectEnvVar, "")
+	// gcloud must never be executed when the configuration directory exists
+	t.Setenv("PATH", "")
+
+	ctx := context.Background()
+	projectID, err := gcloudConfigProjectID(ctx)
+	if err != nil || projectID != "" {
+		t.Errorf("unconfigured gcloud must return no project: %#v, %v", projectID, err)
+	}
+
+	writeFile := func(path string, contents string) {
+		t.Helper()
+		path = filepath.Join(configDir, path)
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(contents), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("configurations/config_default", "[core]\naccount = a@example.com\nproject = default-project\n")
+	writeFile("configurations/config_other", "[compute]\nproject = wrong\n\n[core]\nproject=other-project\n")
+
+	projectID, err = gcloudConfigProjectID(ctx)
+	if err != nil || projectID != "default-project" {
+		t.Errorf("must use the default config: %#v, %v", projectID, err)
+	}
+
+	writeFile("active_config", "other\n")
+	projectID, err = gcloudConfigProjectID(ctx)
+	if err != nil || projectID != "other-project" {
+		t.Errorf("must use active_config: %#v, %v", projectID, err)
+	}
+
+	t.Setenv(gcloudActiveConfigEnvVar, "default")
+	projectID, err = gcloudConfigProjectID(ctx)
+	if err != nil || projectID != "default-project" {
+		t.Errorf("%s must override active_config: %#v, %v", gcloudActiveConfigEnvVar, projectID, err)
+	}
+
+	t.Setenv(gcloudActiveConfigEnvVar, "missing")
+	projectID, err = gcloudConfigProjectID(ctx)
+	if err != nil || projectID != "" {
+		t.Errorf("missing config must return no project: %#v, %v", projectID, err)
+	}
+
+	t.Setenv(gcloudProjectEnvVar, "env-project")
+	projectID, err = gcloudConfigProjectID(ctx)
+	if err != nil || projectID != "env-project" {
+		t.Errorf("%s must override the config: %#v, %v", gcloudProjectEnvVar, projectID, err)
+	}
+}
+
+func TestParseINIValue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"[core]\nproject = p\n", "p"},
+		{"[core]\r\nproject = p\r\n", "p"},
+		{"  [core]  \n  Project:p  \n", "p"},
+		{"[core]\n# project = commented\n; project = commented\nproject = p\n", "p"},
+		{"[other]\nproject = p\n", ""},
+		{"project = p\n[core]\naccount = a\n", ""},
+		{"[core]\nproject = first\nproject = second\n", "second"},
+		{"[core]\nproject_other = p\n", ""},
+	}
+
+	for i, test := range tests {
+		output, err := parseINIValue(strings.NewReader(test.input), "core", "project")
+		if err != nil || output != test.expected {
+			t.Errorf("%d: parseINIValue(%#v)=%#v, %v; expected %#v", i, test.input, output, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package gcpgrpc contains gRPC interceptors that read trace IDs from incoming requests, log each
+// call, and propagate the trace to outgoing requests, so gcpzap logs from gRPC services include the
+// trace.
+package gcpgrpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/evanj/gcplogs"
+	"github.com/evanj/gcplogs/gcpzap"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC metadata keys are lower case versions of the HTTP headers.
+var cloudTraceKey = strings.ToLower(gcplogs.TraceHeader)
+var traceParentKey = strings.ToLower(gcplogs.TraceParentHeader)
+
+// Tracer reads trace IDs from gRPC metadata and stores a trace-scoped logger in the context.
+// Handlers can retrieve the logger with gcpzap.FromContext, and the trace with
+// gcplogs.TraceFromContext. When each call finishes, it logs the method, status code and latency,
+// at INFO for OK, ERROR for codes that indicate a server problem, and WARNING otherwise. Install it
+// with:
+//
+//	grpc.NewServer(grpc.UnaryInterceptor(tracer.UnaryServerInterceptor),
+//		grpc.StreamInterceptor(tracer.StreamServerInterceptor))
+type Tracer struct {
+	gcplogs.Tracer
+	Logger *zap.Logger
+}
+
+// newContext returns ctx with the trace from its incoming metadata and the trace-scoped logger.
+func (t *Tracer) newContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	traceContext := t.TraceContextFromHeaders(firstValue(md, cloudTraceKey), firstValue(md, traceParentKey))
+	if traceContext.Trace != "" {
+		ctx = gcplogs.ContextWithTrace(ctx, traceContext)
+	}
+	return gcpzap.NewContext(ctx, gcpzap.WithTraceFromContext(ctx, t.Logger))
+}
+
+func firstValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// UnaryServerInterceptor is a grpc.UnaryServerInterceptor that stores the trace from the request
+// metadata and a trace-scoped logger in the handler's context, and logs the call.
+func (t *Tracer) UnaryServerInterceptor(
+	ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (any, error) {
+	start := time.Now()
+	ctx = t.newContext(ctx)
+	resp, err := handler(ctx, req)
+	logCall(ctx, info.FullMethod, err, time.Since(start))
+	return resp, err
+}
+
+// StreamServerInterceptor is a grpc.StreamServerInterceptor that stores the trace from the request
+// metadata and a trace-scoped logger in the stream's context, and logs the call.
+func (t *Tracer) StreamServerInterceptor(
+	srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+) error {
+	start := time.Now()
+	ctx := t.newContext(ss.Context())
+	err := handler(srv, &serverStream{ss, ctx})
+	logCall(ctx, info.FullMethod, err, time.Since(start))
+	return err
+}
+
+// logCall logs a finished call with the logger from ctx.
+func logCall(ctx context.Context, method string, err error, latency time.Duration) {
+	code := status.Code(err)
+	fields := []zap.Field{
+		zap.String("method", method),
+		zap.String("code", code.String()),
+		zap.Duration("latency", latency),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	if ce := gcpzap.FromContext(ctx).Check(codeLevel(code), "grpc request"); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// codeLevel returns the level to log a call that returned code. Codes that gRPC maps to HTTP 5xx
+// status codes are errors, like gcpzap's HTTP request logs.
+func codeLevel(code codes.Code) zapcore.Level {
+	switch code {
+	case codes.OK:
+		return zapcore.InfoLevel
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal,
+		codes.Unavailable, codes.DataLoss:
+		return zapcore.ErrorLevel
+	default:
+		return zapcore.WarnLevel
+	}
+}
+
+// serverStream replaces the context of a grpc.ServerStream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// outgoingContext returns ctx with metadata that propagates the trace from
+// gcplogs.TraceFromContext(ctx) with a new child span. It returns ctx unchanged if there is no
+// trace, or the outgoing metadata already has one.
+func outgoingContext(ctx context.Context) context.Context {
+	parent := gcplogs.TraceFromContext(ctx)
+	if parent.Trace == "" {
+		return ctx
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if len(md.Get(cloudTraceKey)) > 0 || len(md.Get(traceParentKey)) > 0 {
+		return ctx
+	}
+
+	child := parent.NewChildSpan()
+	cloudTraceContext, traceParent := child.HeaderValues()
+	ctx = gcplogs.ContextWithTrace(ctx, child)
+	if cloudTraceContext != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, cloudTraceKey, cloudTraceContext)
+	}
+	if traceParent != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, traceParentKey, traceParent)
+	}
+	return ctx
+}
+
+// UnaryClientInterceptor is a grpc.UnaryClientInterceptor that propagates the trace from the
+// context to the server. Install it with grpc.WithUnaryInterceptor(UnaryClientInterceptor).
+func UnaryClientInterceptor(
+	ctx context.Context, method string, req any, reply any, cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+) error {
+	return invoker(outgoingContext(ctx), method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor is a grpc.StreamClientInterceptor that propagates the trace from the
+// context to the server. Install it with grpc.WithStreamInterceptor(StreamClientInterceptor).
+func StreamClientInterceptor(
+	ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+	streamer grpc.Streamer, opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	return streamer(outgoingContext(ctx), desc, cc, method, opts...)
+}
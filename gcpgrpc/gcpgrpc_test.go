@@ -0,0 +1,196 @@
+package gcpgrpc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/evanj/gcplogs"
+	"github.com/evanj/gcplogs/gcpzap"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// healthServer logs with the logger from the context, and records the trace.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+
+	mu     sync.Mutex
+	traces []gcplogs.TraceContext
+}
+
+func (h *healthServer) record(ctx context.Context, message string) {
+	gcpzap.FromContext(ctx).Info(message)
+	h.mu.Lock()
+	h.traces = append(h.traces, gcplogs.TraceFromContext(ctx))
+	h.mu.Unlock()
+}
+
+func (h *healthServer) Check(
+	ctx context.Context, req *healthpb.HealthCheckRequest,
+) (*healthpb.HealthCheckResponse, error) {
+	switch req.Service {
+	case "notfound":
+		return nil, status.Error(codes.NotFound, "unknown service")
+	case "unavailable":
+		return nil, status.Error(codes.Unavailable, "not serving")
+	}
+	h.record(ctx, "check")
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	h.record(stream.Context(), "watch")
+	return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+}
+
+// syncBuffer is a bytes.Buffer that is safe to use from the server's goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+func TestInterceptors(t *testing.T) {
+	logOutput := &syncBuffer{}
+	encoderConfig := gcpzap.NewProductionConfig().EncoderConfig
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(logOutput), zapcore.InfoLevel))
+
+	tracer := &Tracer{gcplogs.Tracer{ProjectID: "projectid"}, logger}
+	server := grpc.NewServer(grpc.UnaryInterceptor(tracer.UnaryServerInterceptor),
+		grpc.StreamInterceptor(tracer.StreamServerInterceptor))
+	health := &healthServer{}
+	healthpb.RegisterHealthServer(server, health)
+
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor),
+		grpc.WithStreamInterceptor(StreamClientInterceptor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	// without a trace
+	ctx := context.Background()
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client interceptors propagate the trace with a new span
+	const traceName = "projects/projectid/traces/4bf92f3577b34da6a3ce929d0e0e4736"
+	parent := gcplogs.TraceContext{
+		Trace: traceName, SpanID: "00f067aa0ba902b7", Sampled: true, SampledKnown: true,
+	}
+	ctx = gcplogs.ContextWithTrace(ctx, parent)
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// metadata set by the caller is used instead
+	const callerTraceParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"
+	ctx = metadata.AppendToOutgoingContext(ctx, "traceparent", callerTraceParent)
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	health.mu.Lock()
+	traces := health.traces
+	health.mu.Unlock()
+	if len(traces) != 4 {
+		t.Fatalf("expected 4 requests: %#v", traces)
+	}
+	if traces[0] != (gcplogs.TraceContext{}) {
+		t.Errorf("request without a trace must not have one: %#v", traces[0])
+	}
+	for i, trace := range traces[1:3] {
+		if trace.Trace != traceName || !trace.Sampled || trace.SpanID == parent.SpanID || trace.SpanID == "" {
+			t.Errorf("%d: server must receive the trace with a child span: %#v", i, trace)
+		}
+	}
+	expected := gcplogs.TraceContext{
+		Trace:        "projects/projectid/traces/0af7651916cd43dd8448eb211c80319c",
+		SpanID:       "b7ad6b7169203331",
+		SampledKnown: true,
+	}
+	if traces[3] != expected {
+		t.Errorf("server trace=%#v; expected %#v", traces[3], expected)
+	}
+
+	logString := logOutput.String()
+	for _, message := range []string{"check", "watch"} {
+		expectedLog := `"message":"` + message + `","logging.googleapis.com/trace":"` + traceName + `"`
+		if !strings.Contains(logString, expectedLog) {
+			t.Errorf("log should contain %#v; %#v", expectedLog, logString)
+		}
+	}
+	if !strings.Contains(logString, `"message":"check"}`) {
+		t.Errorf("log should contain a check without a trace: %#v", logString)
+	}
+
+	// each call is logged, with a severity from the status code
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "notfound"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatal(err)
+	}
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unavailable"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatal(err)
+	}
+	logString = logOutput.String()
+	for _, expected := range []string{
+		`{"severity":"INFO",[^\n]*"message":"grpc request",` +
+			`"method":"/grpc.health.v1.Health/Check","code":"OK","latency":`,
+		`{"severity":"INFO",[^\n]*"message":"grpc request","logging.googleapis.com/trace":"` +
+			traceName + `"[^\n]*"method":"/grpc.health.v1.Health/Watch","code":"OK"`,
+		`{"severity":"WARNING",[^\n]*"message":"grpc request",[^\n]*"code":"NotFound",` +
+			`[^\n]*"error":"rpc error: code = NotFound desc = unknown service"`,
+		`{"severity":"ERROR",[^\n]*"message":"grpc request",[^\n]*"code":"Unavailable"`,
+	} {
+		if !regexp.MustCompile(expected).MatchString(logString) {
+			t.Errorf("log should match %#v; %#v", expected, logString)
+		}
+	}
+}
@@ -3,15 +3,11 @@
 package gcplogs
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"net/http"
-	"os"
-	"os/exec"
+	"strconv"
 	"strings"
-
-	"golang.org/x/oauth2/google"
 )
 
 // ProjectEnvVar is the environment variable name for configuring a Google Cloud Project ID.
@@ -20,10 +16,27 @@ const ProjectEnvVar = "GOOGLE_CLOUD_PROJECT"
 // TraceHeader is the HTTP header containing trace IDs on Google Cloud.
 const TraceHeader = "X-Cloud-Trace-Context"
 
+// TraceParentHeader is the W3C Trace Context HTTP header containing trace IDs. See:
+// https://www.w3.org/TR/trace-context/#traceparent-header
+const TraceParentHeader = "traceparent"
+
 // TraceKey is the log key for trace IDs. See:
 // https://cloud.google.com/logging/docs/agent/configuration#special-fields
 const TraceKey = "logging.googleapis.com/trace"
 
+// SpanIDKey is the log key for the span ID within a trace.
+const SpanIDKey = "logging.googleapis.com/spanId"
+
+// TraceSampledKey is the log key for the boolean that is true if the trace is sampled.
+const TraceSampledKey = "logging.googleapis.com/trace_sampled"
+
+// SourceLocationKey is the log key for the source code location. See:
+// https://cloud.google.com/logging/docs/structured-logging#special-payload-fields
+const SourceLocationKey = "logging.googleapis.com/sourceLocation"
+
+// LabelsKey is the log key for the map of user-defined labels, which Cloud Logging indexes.
+const LabelsKey = "logging.googleapis.com/labels"
+
 // DefaultProjectID detects the current Google Cloud project ID, or return the empty string if it
 // fails. This function reads files, makes HTTP requests, and might execute binaries. An
 // application should not call it often. It is possible for the result to change while the
@@ -39,53 +52,16 @@ const TraceKey = "logging.googleapis.com/trace"
 //
 // The approaches it uses are:
 // * GOOGLE_CLOUD_PROJECT environment variable (manual, App Engine, Cloud Shell)
-// * Application default credentials (Compute Engine, service account key)
-// * Gcloud default project
+// * Application default credentials file (service account key)
+// * Metadata server (Compute Engine, Cloud Run, App Engine, Kubernetes Engine), if running on GCP
+// * Gcloud default project, from its configuration files or by executing gcloud
+//
+// Use ProjectIDResolver to find out why it failed, or to customize the sources.
 func DefaultProjectID() string {
-	// environment variable: manually configured, new App Engine, Cloud Shell
-	projectID := os.Getenv(ProjectEnvVar)
-	if projectID != "" {
-		return projectID
-	}
-
-	// contains a project ID on compute engine or when using a key file
-	// does NOT contain a project ID with personal gcloud credentials
-	projectID, _ = defaultCredentialsProjectID()
-	if projectID != "" {
-		return projectID
-	}
-
-	projectID, _ = gcloudConfigProjectID()
+	projectID, _ := (&ProjectIDResolver{}).Resolve(context.Background())
 	return projectID
 }
 
-// Return the project ID from application default credentials. This works on compute engine
-// or when using a service account key.
-func defaultCredentialsProjectID() (string, error) {
-	ctx := context.Background()
-	creds, err := google.FindDefaultCredentials(ctx)
-
-	if err != nil {
-		return "", err
-	}
-	return creds.ProjectID, nil
-}
-
-func gcloudConfigProjectID() (string, error) {
-	// attempt to load the gcloud config by executing gcloud
-	// TODO: Java reads the configuration file directly?
-	// attempt to execute gcloud
-	cmd := exec.Command("gcloud", "config", "get-value", "core/project")
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("failed to get default project from gcloud: %s",
-			err.Error())
-	}
-	// out contains the value with a new line
-	projectID := string(bytes.TrimSpace(out))
-	return projectID, nil
-}
-
 // Tracer parses trace IDs in the Stackdriver Trace format. ProjectID must be set to a non-empty
 // string, otherwise it will never produce trace IDs. Use &Tracer{DefaultProjectID()} to attempt
 // auto-detection.
@@ -93,20 +69,112 @@ type Tracer struct {
 	ProjectID string
 }
 
-// FromRequest returns the trace ID from a cloud trace header in an HTTP request, or the empty
-// string if it does not exist. See:
+// TraceContext contains the trace information from a request, formatted for Cloud Logging.
+type TraceContext struct {
+	// Trace is the trace resource name: projects/PROJECT_ID/traces/TRACE_ID
+	Trace string
+	// SpanID is the span ID as 16 hex characters, or the empty string if it is not known.
+	SpanID string
+	// Sampled is true if the request is being traced.
+	Sampled bool
+	// SampledKnown is true if the header contained the sampling decision. Otherwise, Sampled is
+	// false, and the decision is left to the next service.
+	SampledKnown bool
+}
+
+// FromRequest returns the trace ID from the X-Cloud-Trace-Context or W3C traceparent header in an
+// HTTP request, or the empty string if neither exists. See:
 // https://cloud.google.com/trace/docs/troubleshooting#force-trace
+// https://www.w3.org/TR/trace-context/#traceparent-header
 func (t *Tracer) FromRequest(r *http.Request) string {
+	return t.TraceContextFromRequest(r).Trace
+}
+
+// TraceContextFromRequest returns the trace ID, span ID and sampled flag from the
+// X-Cloud-Trace-Context or W3C traceparent header in an HTTP request. If both exist,
+// X-Cloud-Trace-Context is used. It returns the zero TraceContext if neither exists.
+func (t *Tracer) TraceContextFromRequest(r *http.Request) TraceContext {
+	return t.TraceContextFromRequestPreferring(r, TraceHeader)
+}
+
+// TraceContextFromRequestPreferring is TraceContextFromRequest, but uses preferredHeader if the
+// request contains both valid headers. preferredHeader must be TraceHeader or TraceParentHeader.
+func (t *Tracer) TraceContextFromRequestPreferring(
+	r *http.Request, preferredHeader string,
+) TraceContext {
+	return t.traceContextFromHeaders(r.Header.Get(TraceHeader), r.Header.Get(TraceParentHeader),
+		preferredHeader == TraceParentHeader)
+}
+
+// TraceContextFromHeaders returns the trace ID, span ID and sampled flag from the values of the
+// X-Cloud-Trace-Context and W3C traceparent headers. Either can be empty; if both are valid,
+// X-Cloud-Trace-Context is used. This is useful for protocols other than HTTP, such as gRPC
+// metadata.
+func (t *Tracer) TraceContextFromHeaders(cloudTraceContext string, traceParent string) TraceContext {
+	return t.traceContextFromHeaders(cloudTraceContext, traceParent, false)
+}
+
+func (t *Tracer) traceContextFromHeaders(
+	cloudTraceContext string, traceParent string, preferTraceParent bool,
+) TraceContext {
 	if t.ProjectID == "" {
-		return ""
+		return TraceContext{}
 	}
 
-	headerValue := r.Header.Get(TraceHeader)
-	slashIndex := strings.IndexByte(headerValue, '/')
-	if slashIndex < 0 {
-		return ""
+	cloudTrace, cloudTraceOK := parseCloudTraceContext(cloudTraceContext)
+	parent, traceParentOK := parseTraceParent(traceParent)
+	if traceParentOK && (!cloudTraceOK || preferTraceParent) {
+		return TraceContext{
+			Trace:        t.traceName(parent.traceID),
+			SpanID:       parent.parentID,
+			Sampled:      parent.flags&traceParentSampled != 0,
+			SampledKnown: true,
+		}
+	}
+	if cloudTraceOK {
+		return TraceContext{
+			Trace:        t.traceName(cloudTrace.traceID),
+			SpanID:       cloudTrace.spanID,
+			Sampled:      cloudTrace.sampled,
+			SampledKnown: cloudTrace.sampledKnown,
+		}
 	}
-	traceID := headerValue[:slashIndex]
+	return TraceContext{}
+}
 
+func (t *Tracer) traceName(traceID string) string {
 	return "projects/" + t.ProjectID + "/traces/" + traceID
 }
+
+// cloudTraceContext contains the fields of an X-Cloud-Trace-Context header.
+type cloudTraceContext struct {
+	traceID string
+	// spanID is converted from the decimal header value to 16 hex characters
+	spanID       string
+	sampled      bool
+	sampledKnown bool
+}
+
+// parseCloudTraceContext parses an X-Cloud-Trace-Context header: TRACE_ID/SPAN_ID;o=TRACE_TRUE.
+// It returns false if there is no trace ID. An invalid SPAN_ID is ignored.
+func parseCloudTraceContext(headerValue string) (cloudTraceContext, bool) {
+	slashIndex := strings.IndexByte(headerValue, '/')
+	if slashIndex <= 0 {
+		return cloudTraceContext{}, false
+	}
+	result := cloudTraceContext{traceID: headerValue[:slashIndex]}
+
+	spanAndOptions := headerValue[slashIndex+1:]
+	options := ""
+	if semicolonIndex := strings.IndexByte(spanAndOptions, ';'); semicolonIndex >= 0 {
+		options = spanAndOptions[semicolonIndex+1:]
+		spanAndOptions = spanAndOptions[:semicolonIndex]
+	}
+	spanID, err := strconv.ParseUint(spanAndOptions, 10, 64)
+	if err == nil && spanID != 0 {
+		result.spanID = fmt.Sprintf("%016x", spanID)
+	}
+	result.sampled = options == "o=1"
+	result.sampledKnown = strings.HasPrefix(options, "o=")
+	return result, true
+}
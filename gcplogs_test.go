@@ -13,7 +13,8 @@ func TestDefaultProjectID(t *testing.T) {
 	tempDir := t.TempDir()
 
 	// save the state of special environment variables and remove them so the test works
-	specialEnvVars := []string{ProjectEnvVar, "GOOGLE_APPLICATION_CREDENTIALS", "PATH"}
+	specialEnvVars := []string{ProjectEnvVar, "GOOGLE_APPLICATION_CREDENTIALS", "PATH",
+		gcloudConfigDirEnvVar, gcloudActiveConfigEnvVar, gcloudProjectEnvVar, MetadataHostEnvVar}
 	origValues := map[string]string{}
 	for _, key := range specialEnvVars {
 		origValues[key] = os.Getenv(key)
@@ -25,6 +26,8 @@ func TestDefaultProjectID(t *testing.T) {
 		}
 	}()
 	os.Setenv("PATH", tempDir)
+	// gcloud is not configured: falls back to executing gcloud
+	os.Setenv(gcloudConfigDirEnvVar, filepath.Join(tempDir, "does_not_exist"))
 
 	projectID := DefaultProjectID()
 	if projectID != "" {
@@ -63,8 +66,6 @@ func TestDefaultProjectID(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	// fmt.Println("WTF", tempdir)
-	// time.Sleep(time.Minute)
 	projectID = DefaultProjectID()
 	if projectID != "gcloud-project-id" {
 		t.Error("incorrect gcloud project:", projectID)
@@ -126,3 +127,81 @@ func TestTracerFromRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestTracerFromRequestTraceParent(t *testing.T) {
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	const cloudTrace = "105445aa7843bc8bf206b120001000/0;o=1"
+	const cloudTraceName = "projects/test_id/traces/105445aa7843bc8bf206b120001000"
+	const traceParentName = "projects/test_id/traces/4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		input       string
+		traceParent string
+		expected    string
+		// expectedPreferring is the trace when preferring traceparent
+		expectedPreferring string
+	}{
+		{"", traceParent, traceParentName, traceParentName},
+		{"", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", ""},
+		{"invalid", traceParent, traceParentName, traceParentName},
+		{cloudTrace, traceParent, cloudTraceName, traceParentName},
+		// falls back to X-Cloud-Trace-Context if traceparent is invalid
+		{cloudTrace, "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			cloudTraceName, cloudTraceName},
+	}
+
+	tracer := &Tracer{"test_id"}
+	for i, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(TraceHeader, test.input)
+		req.Header.Set(TraceParentHeader, test.traceParent)
+
+		output := tracer.FromRequest(req)
+		if output != test.expected {
+			t.Errorf("%d: FromRequest(%#v, %#v)=%#v; expected %#v",
+				i, test.input, test.traceParent, output, test.expected)
+		}
+		output = tracer.TraceContextFromRequestPreferring(req, TraceParentHeader).Trace
+		if output != test.expectedPreferring {
+			t.Errorf("%d: TraceContextFromRequestPreferring(%#v, %#v)=%#v; expected %#v",
+				i, test.input, test.traceParent, output, test.expectedPreferring)
+		}
+	}
+}
+
+func TestTracerTraceContextFromRequest(t *testing.T) {
+	tests := []struct {
+		input       string
+		traceParent string
+		expected    TraceContext
+	}{
+		{"", "", TraceContext{}},
+		{"/123", "", TraceContext{}},
+		{"105445aa7843bc8bf206b120001000/0;o=1", "",
+			TraceContext{"projects/test_id/traces/105445aa7843bc8bf206b120001000", "", true, true}},
+		{"105445aa7843bc8bf206b120001000/123;o=1", "",
+			TraceContext{"projects/test_id/traces/105445aa7843bc8bf206b120001000", "000000000000007b", true, true}},
+		{"105445aa7843bc8bf206b120001000/18446744073709551615;o=0", "",
+			TraceContext{"projects/test_id/traces/105445aa7843bc8bf206b120001000", "ffffffffffffffff", false, true}},
+		{"105445aa7843bc8bf206b120001000/123", "",
+			TraceContext{"projects/test_id/traces/105445aa7843bc8bf206b120001000", "000000000000007b", false, false}},
+		{"105445aa7843bc8bf206b120001000/notdecimal;o=1", "",
+			TraceContext{"projects/test_id/traces/105445aa7843bc8bf206b120001000", "", true, true}},
+		{"", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			TraceContext{"projects/test_id/traces/4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, true}},
+		{"", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-02",
+			TraceContext{"projects/test_id/traces/4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false, true}},
+	}
+
+	tracer := &Tracer{ProjectID: "test_id"}
+	for i, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(TraceHeader, test.input)
+		req.Header.Set(TraceParentHeader, test.traceParent)
+
+		output := tracer.TraceContextFromRequest(req)
+		if output != test.expected {
+			t.Errorf("%d: TraceContextFromRequest(%#v, %#v)=%#v; expected %#v",
+				i, test.input, test.traceParent, output, test.expected)
+		}
+	}
+}
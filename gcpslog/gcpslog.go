@@ -0,0 +1,357 @@
+// Package gcpslog implements a log/slog Handler that writes logs in the JSON format understood by
+// Google Cloud Logging. It writes the same keys as gcpzap's stackdriver_json encoder.
+package gcpslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/evanj/gcplogs"
+)
+
+const severityKey = "severity"
+const timeKey = "time"
+const messageKey = "message"
+
+// SourceLocationKey is the log key for the source code location. It is gcplogs.SourceLocationKey.
+const SourceLocationKey = gcplogs.SourceLocationKey
+
+// The severities for slog levels, starting at slog.LevelDebug. slog levels are 4 apart, so this
+// maps DEBUG, INFO, WARN and ERROR to the same severities as gcpzap. Levels above ERROR use the
+// severities for zap's DPanic, Panic and Fatal levels.
+var levelSeverity = [...]string{
+	"DEBUG",
+	"INFO",
+	"WARNING",
+	"ERROR",
+	"CRITICAL",
+	"ALERT",
+	"EMERGENCY",
+}
+
+// severity returns the Cloud Logging severity for level. Levels between the standard slog levels
+// round down, and levels outside the range are clamped.
+func severity(level slog.Level) string {
+	index := (int(level) - int(slog.LevelDebug)) / (int(slog.LevelInfo) - int(slog.LevelDebug))
+	if level < slog.LevelDebug {
+		index = 0
+	}
+	if index >= len(levelSeverity) {
+		index = len(levelSeverity) - 1
+	}
+	return levelSeverity[index]
+}
+
+// HandlerOptions are options for a Handler. A zero HandlerOptions uses the defaults.
+type HandlerOptions struct {
+	// AddSource writes logging.googleapis.com/sourceLocation with the caller's file, line and
+	// function.
+	AddSource bool
+
+	// Level is the minimum level that will be logged. The default is slog.LevelInfo.
+	Level slog.Leveler
+}
+
+// Handler is a slog.Handler that writes one JSON object per line in the format understood by
+// Google Cloud Logging. It is safe for concurrent use.
+type Handler struct {
+	opts HandlerOptions
+	// attributes and groups from WithAttrs and WithGroup, in the order they were added
+	goas []groupOrAttrs
+	mu   *sync.Mutex
+	w    io.Writer
+}
+
+// groupOrAttrs holds either a group name or a list of attributes.
+type groupOrAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewHandler creates a Handler that writes to w. If opts is nil, it uses the default options.
+func NewHandler(w io.Writer, opts *HandlerOptions) *Handler {
+	h := &Handler{mu: &sync.Mutex{}, w: w}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+	return h
+}
+
+// NewProduction returns a *slog.Logger that writes to stderr at info level and above, with source
+// locations. It is the slog equivalent of gcpzap.NewProduction.
+func NewProduction() *slog.Logger {
+	return slog.New(NewHandler(os.Stderr, &HandlerOptions{AddSource: true}))
+}
+
+// Enabled reports whether the handler handles records at level.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+// WithAttrs returns a new Handler that includes attrs in every record.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.withGroupOrAttrs(groupOrAttrs{attrs: attrs})
+}
+
+// WithGroup returns a new Handler that puts all following attributes in the group name.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.withGroupOrAttrs(groupOrAttrs{group: name})
+}
+
+func (h *Handler) withGroupOrAttrs(goa groupOrAttrs) *Handler {
+	h2 := *h
+	h2.goas = make([]groupOrAttrs, len(h.goas)+1)
+	copy(h2.goas, h.goas)
+	h2.goas[len(h2.goas)-1] = goa
+	return &h2
+}
+
+// Handle writes r as a single line of JSON.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	buf := make([]byte, 0, 1024)
+	buf = append(buf, '{')
+	buf = appendKey(buf, severityKey)
+	buf = appendString(buf, severity(r.Level))
+
+	if !r.Time.IsZero() {
+		buf = appendKey(buf, timeKey)
+		buf = appendTime(buf, r.Time)
+	}
+
+	if h.opts.AddSource && r.PC != 0 {
+		frames := runtime.CallersFrames([]uintptr{r.PC})
+		frame, _ := frames.Next()
+		buf = appendKey(buf, SourceLocationKey)
+		buf = append(buf, '{')
+		buf = appendKey(buf, "file")
+		buf = appendString(buf, frame.File)
+		buf = appendKey(buf, "line")
+		buf = strconv.AppendInt(buf, int64(frame.Line), 10)
+		buf = appendKey(buf, "function")
+		buf = appendString(buf, frame.Function)
+		buf = append(buf, '}')
+	}
+
+	buf = appendKey(buf, messageKey)
+	buf = appendString(buf, r.Message)
+
+	if traceContext := traceFromContext(ctx); traceContext.Trace != "" {
+		buf = appendKey(buf, gcplogs.TraceKey)
+		buf = appendString(buf, traceContext.Trace)
+		if traceContext.SpanID != "" {
+			buf = appendKey(buf, gcplogs.SpanIDKey)
+			buf = appendString(buf, traceContext.SpanID)
+		}
+		if traceContext.SampledKnown {
+			buf = appendKey(buf, gcplogs.TraceSampledKey)
+			buf = strconv.AppendBool(buf, traceContext.Sampled)
+		}
+	}
+
+	// groups without any attributes must not be written
+	goas := h.goas
+	if r.NumAttrs() == 0 {
+		for len(goas) > 0 && goas[len(goas)-1].group != "" {
+			goas = goas[:len(goas)-1]
+		}
+	}
+	openGroups := 0
+	for _, goa := range goas {
+		if goa.group != "" {
+			buf = appendKey(buf, goa.group)
+			buf = append(buf, '{')
+			openGroups++
+			continue
+		}
+		for _, a := range goa.attrs {
+			buf = appendAttr(buf, a)
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		buf = appendAttr(buf, a)
+		return true
+	})
+	for i := 0; i < openGroups; i++ {
+		buf = append(buf, '}')
+	}
+	buf = append(buf, '}', '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf)
+	return err
+}
+
+func appendAttr(buf []byte, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return buf
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return buf
+		}
+		// groups with empty keys are inlined
+		if a.Key != "" {
+			buf = appendKey(buf, a.Key)
+			buf = append(buf, '{')
+		}
+		for _, groupAttr := range attrs {
+			buf = appendAttr(buf, groupAttr)
+		}
+		if a.Key != "" {
+			buf = append(buf, '}')
+		}
+		return buf
+	}
+
+	if a.Key == "" {
+		return buf
+	}
+	buf = appendKey(buf, a.Key)
+	return appendValue(buf, a.Value)
+}
+
+func appendValue(buf []byte, v slog.Value) []byte {
+	switch v.Kind() {
+	case slog.KindString:
+		return appendString(buf, v.String())
+	case slog.KindInt64:
+		return strconv.AppendInt(buf, v.Int64(), 10)
+	case slog.KindUint64:
+		return strconv.AppendUint(buf, v.Uint64(), 10)
+	case slog.KindFloat64:
+		return appendFloat(buf, v.Float64())
+	case slog.KindBool:
+		return strconv.AppendBool(buf, v.Bool())
+	case slog.KindDuration:
+		// the same as zap.NewProductionConfig: seconds as a float
+		return appendFloat(buf, v.Duration().Seconds())
+	case slog.KindTime:
+		return appendTime(buf, v.Time())
+	default:
+		return appendAny(buf, v.Any())
+	}
+}
+
+func appendAny(buf []byte, value any) []byte {
+	_, isMarshaler := value.(json.Marshaler)
+	if err, ok := value.(error); ok && !isMarshaler {
+		return appendString(buf, err.Error())
+	}
+	serialized, err := json.Marshal(value)
+	if err != nil {
+		// never lose a log record because a value cannot be serialized
+		return appendString(buf, fmt.Sprintf("%+v", value))
+	}
+	return append(buf, serialized...)
+}
+
+func appendFloat(buf []byte, f float64) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		// JSON has no representation for these
+		return appendString(buf, strconv.FormatFloat(f, 'g', -1, 64))
+	}
+	return strconv.AppendFloat(buf, f, 'g', -1, 64)
+}
+
+func appendTime(buf []byte, t time.Time) []byte {
+	// the same format as gcpzap: RFC3339 with nanoseconds in UTC
+	buf = append(buf, '"')
+	buf = t.UTC().AppendFormat(buf, time.RFC3339Nano)
+	return append(buf, '"')
+}
+
+// appendKey appends key and a colon, with a comma if it is not the first key in an object.
+func appendKey(buf []byte, key string) []byte {
+	if buf[len(buf)-1] != '{' {
+		buf = append(buf, ',')
+	}
+	buf = appendString(buf, key)
+	return append(buf, ':')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendString appends s as a quoted JSON string. Invalid UTF-8 is replaced with U+FFFD.
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+// WithTrace returns a copy of ctx that carries the trace ID, span ID and sampled flag from r, if
+// they are set. It is the same as gcplogs.ContextWithTrace with tracer.TraceContextFromRequest(r).
+// Handler writes the trace for records logged with a context that has one, such as with
+// slog.Logger.InfoContext.
+func WithTrace(ctx context.Context, tracer *gcplogs.Tracer, r *http.Request) context.Context {
+	traceContext := tracer.TraceContextFromRequest(r)
+	if traceContext.Trace == "" {
+		return ctx
+	}
+	return gcplogs.ContextWithTrace(ctx, traceContext)
+}
+
+func traceFromContext(ctx context.Context) gcplogs.TraceContext {
+	if ctx == nil {
+		return gcplogs.TraceContext{}
+	}
+	return gcplogs.TraceFromContext(ctx)
+}
@@ -0,0 +1,160 @@
+package gcpslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/slogtest"
+	"time"
+
+	"github.com/evanj/gcplogs"
+)
+
+func parseLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var results []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var m map[string]any
+		err := json.Unmarshal(line, &m)
+		if err != nil {
+			t.Fatalf("invalid JSON line %#v: %s", string(line), err)
+		}
+		results = append(results, m)
+	}
+	return results
+}
+
+func TestSlogtest(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := NewHandler(buf, nil)
+
+	results := func() []map[string]any {
+		lines := parseLines(t, buf)
+		// slogtest expects the standard slog keys
+		for _, m := range lines {
+			if message, ok := m[messageKey]; ok {
+				m[slog.MessageKey] = message
+				delete(m, messageKey)
+			}
+			if severity, ok := m[severityKey]; ok {
+				m[slog.LevelKey] = severity
+				delete(m, severityKey)
+			}
+		}
+		return lines
+	}
+	err := slogtest.TestHandler(h, results)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		level    slog.Level
+		expected string
+	}{
+		{slog.LevelDebug - 4, "DEBUG"},
+		{slog.LevelDebug, "DEBUG"},
+		{slog.LevelInfo - 1, "DEBUG"},
+		{slog.LevelInfo, "INFO"},
+		{slog.LevelInfo + 2, "INFO"},
+		{slog.LevelWarn, "WARNING"},
+		{slog.LevelError, "ERROR"},
+		{slog.LevelError + 4, "CRITICAL"},
+		{slog.LevelError + 8, "ALERT"},
+		{slog.LevelError + 12, "EMERGENCY"},
+		{slog.LevelError + 100, "EMERGENCY"},
+	}
+	for i, test := range tests {
+		output := severity(test.level)
+		if output != test.expected {
+			t.Errorf("%d: severity(%s)=%s; expected %s", i, test.level, output, test.expected)
+		}
+	}
+}
+
+func TestHandlerFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := NewHandler(buf, &HandlerOptions{Level: slog.LevelDebug})
+
+	r := slog.NewRecord(time.Unix(1551033753, 929117000), slog.LevelDebug, "message \"quoted\"\n", 0)
+	r.AddAttrs(slog.Int("example", 42), slog.Duration("latency", 1500*time.Millisecond),
+		slog.Any("err", errors.New("some error")), slog.String("invalid", "\xff\x01"))
+	err := h.Handle(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const expected = `{"severity":"DEBUG","time":"2019-02-24T18:42:33.929117Z",` +
+		`"message":"message \"quoted\"\n","example":42,"latency":1.5,"err":"some error",` +
+		`"invalid":"` + "\ufffd" + `\u0001"}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("expected:%#v; got %#v", expected, buf.String())
+	}
+}
+
+func TestSourceLocation(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(NewHandler(buf, &HandlerOptions{AddSource: true}))
+	logger.Info("message")
+
+	lines := parseLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line: %#v", buf.String())
+	}
+	sourceLocation, ok := lines[0][SourceLocationKey].(map[string]any)
+	if !ok {
+		t.Fatalf("missing %s: %#v", SourceLocationKey, buf.String())
+	}
+	if !strings.HasSuffix(sourceLocation["file"].(string), "gcpslog_test.go") {
+		t.Errorf("wrong file: %#v", sourceLocation)
+	}
+	if sourceLocation["line"].(float64) <= 0 {
+		t.Errorf("wrong line: %#v", sourceLocation)
+	}
+	if !strings.HasSuffix(sourceLocation["function"].(string), ".TestSourceLocation") {
+		t.Errorf("wrong function: %#v", sourceLocation)
+	}
+}
+
+func TestWithTrace(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(NewHandler(buf, nil))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(gcplogs.TraceHeader, "traceid/1;o=1")
+	tracer := &gcplogs.Tracer{ProjectID: "projectid"}
+	ctx := WithTrace(r.Context(), tracer, r)
+	logger.WithGroup("group").InfoContext(ctx, "message", "key", "value")
+
+	const expected = `"message":"message","logging.googleapis.com/trace":` +
+		`"projects/projectid/traces/traceid","logging.googleapis.com/spanId":"0000000000000001",` +
+		`"logging.googleapis.com/trace_sampled":true,"group":{"key":"value"}}`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("log should contain %#v; %#v", expected, buf.String())
+	}
+
+	// no sampling decision: trace_sampled is not written
+	buf.Reset()
+	r.Header.Set(gcplogs.TraceHeader, "traceid/1")
+	logger.InfoContext(WithTrace(r.Context(), tracer, r), "message")
+	const expectedUnknown = `"logging.googleapis.com/spanId":"0000000000000001"}`
+	if !strings.Contains(buf.String(), expectedUnknown) {
+		t.Errorf("log should contain %#v; %#v", expectedUnknown, buf.String())
+	}
+
+	// no trace header: ctx must be unchanged
+	r.Header.Del(gcplogs.TraceHeader)
+	if WithTrace(r.Context(), tracer, r) != r.Context() {
+		t.Error("WithTrace must return ctx if there is no trace")
+	}
+}
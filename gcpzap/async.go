@@ -0,0 +1,305 @@
+package gcpzap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// OverflowPolicy selects what AsyncWriter does when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until there is space in the buffer.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropLowestSeverity drops the oldest entry with the lowest severity, which may be the
+	// new entry.
+	OverflowDropLowestSeverity
+	// OverflowDropNewest drops the new entry.
+	OverflowDropNewest
+)
+
+// Defaults for AsyncWriterConfig.
+const (
+	DefaultAsyncBufferSize    = 4096
+	DefaultDropReportInterval = 10 * time.Second
+)
+
+// AsyncWriterConfig configures an AsyncWriter. The zero value uses the defaults.
+type AsyncWriterConfig struct {
+	// BufferSize is the maximum number of entries waiting to be written. If zero,
+	// DefaultAsyncBufferSize is used.
+	BufferSize int
+	// Policy selects what Write does when the buffer is full. The default is OverflowBlock.
+	Policy OverflowPolicy
+	// DropReportInterval is how often a WARNING entry with the number of dropped entries is
+	// written, if entries were dropped. If zero, DefaultDropReportInterval is used.
+	DropReportInterval time.Duration
+}
+
+// AsyncWriter is a zapcore.WriteSyncer that writes entries to another WriteSyncer from a
+// background goroutine, so logging does not wait for a slow output, such as a throttled stdout.
+// Entries wait in a bounded buffer; Policy selects what happens when it is full. It expects
+// entries written by the gcpzap encoder, which start with the severity. Call Sync or Close before
+// exiting to write the buffered entries.
+type AsyncWriter struct {
+	out    zapcore.WriteSyncer
+	config AsyncWriterConfig
+
+	mu sync.Mutex
+	// signalled when entries are added or written, or the state changes
+	cond *sync.Cond
+	// queues has a queue of entries for each severity rank, so the oldest entry with the lowest
+	// severity is dropped without searching the buffer
+	queues [len(logLevelSeverity) + 1][]asyncEntry
+	count  int
+	// nextSeq is the sequence number of the next entry, to write entries in order
+	nextSeq uint64
+	// writingSeq is the sequence number of the entry being written, if writingEntry is true
+	writingSeq    uint64
+	writingEntry  bool
+	writingReport bool
+	// reports is the number of dropped entry reports that were written
+	reports   uint64
+	dropped   int
+	reportDue bool
+	closed    bool
+	err       error
+
+	stopped chan struct{}
+}
+
+type asyncEntry struct {
+	line []byte
+	seq  uint64
+}
+
+// NewAsyncWriter returns an AsyncWriter that writes to out, and starts its goroutines.
+func NewAsyncWriter(out zapcore.WriteSyncer, config AsyncWriterConfig) *AsyncWriter {
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultAsyncBufferSize
+	}
+	if config.DropReportInterval <= 0 {
+		config.DropReportInterval = DefaultDropReportInterval
+	}
+	w := &AsyncWriter{out: out, config: config, stopped: make(chan struct{})}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	go w.reportDropped()
+	return w
+}
+
+// Write copies an entry to the buffer. If the buffer is full, it waits or drops an entry, depending
+// on the policy. Dropped entries are not errors: they are counted and reported in a WARNING entry.
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	// zap reuses p after Write returns
+	line := make([]byte, len(p))
+	copy(line, p)
+	severity := entrySeverity(p)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.count == w.config.BufferSize && !w.closed {
+		switch w.config.Policy {
+		case OverflowDropNewest:
+			w.dropped++
+			return len(p), nil
+		case OverflowDropLowestSeverity:
+			lowest := w.lowestSeverity()
+			if lowest >= severity {
+				w.dropped++
+				return len(p), nil
+			}
+			w.queues[lowest][0] = asyncEntry{}
+			w.queues[lowest] = w.queues[lowest][1:]
+			w.count--
+			w.dropped++
+		default:
+			w.cond.Wait()
+		}
+	}
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	w.queues[severity] = append(w.queues[severity], asyncEntry{line, w.nextSeq})
+	w.nextSeq++
+	w.count++
+	w.cond.Broadcast()
+	return len(p), nil
+}
+
+// Sync waits until the entries written before it was called are written or dropped, writes the
+// number of dropped entries if any, then syncs the output. It does not wait for entries written
+// while it waits. It returns the errors from writing entries since the last call to Sync.
+func (w *AsyncWriter) Sync() error {
+	w.mu.Lock()
+	end := w.nextSeq
+	for w.oldestPending() < end {
+		w.cond.Wait()
+	}
+	reports := w.reports
+	if w.writingReport {
+		reports++
+	}
+	if w.dropped > 0 {
+		reports++
+		w.reportDue = true
+		w.cond.Broadcast()
+	}
+	for w.reports < reports {
+		w.cond.Wait()
+	}
+	err := w.err
+	w.err = nil
+	w.mu.Unlock()
+	return errors.Join(err, w.out.Sync())
+}
+
+// Close writes all buffered entries, stops the background goroutines, and syncs the output.
+// Entries written after Close are dropped.
+func (w *AsyncWriter) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	<-w.stopped
+	return w.Sync()
+}
+
+// lowestSeverity returns the lowest severity rank of the buffered entries. The buffer must not be
+// empty.
+func (w *AsyncWriter) lowestSeverity() int {
+	severity := 0
+	for len(w.queues[severity]) == 0 {
+		severity++
+	}
+	return severity
+}
+
+// oldest returns the severity rank of the oldest buffered entry. The buffer must not be empty.
+func (w *AsyncWriter) oldest() int {
+	oldest := -1
+	for severity, queue := range w.queues {
+		if len(queue) > 0 && (oldest < 0 || queue[0].seq < w.queues[oldest][0].seq) {
+			oldest = severity
+		}
+	}
+	return oldest
+}
+
+// oldestPending returns the sequence number of the oldest entry that is buffered or being written,
+// or nextSeq if there are none.
+func (w *AsyncWriter) oldestPending() uint64 {
+	pending := w.nextSeq
+	if w.writingEntry {
+		pending = w.writingSeq
+	}
+	if w.count > 0 {
+		pending = min(pending, w.queues[w.oldest()][0].seq)
+	}
+	return pending
+}
+
+// run writes entries and drop reports until Close is called and the buffer is empty.
+func (w *AsyncWriter) run() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		for w.count == 0 && !w.reportDue && !w.closed {
+			w.cond.Wait()
+		}
+
+		// report dropped entries first, so they are reported while the buffer stays full
+		var line []byte
+		if w.dropped > 0 && (w.reportDue || (w.closed && w.count == 0)) {
+			line = droppedWarning(time.Now(), w.dropped)
+			w.dropped = 0
+			w.reportDue = false
+			w.writingReport = true
+		} else if w.count > 0 {
+			oldest := w.oldest()
+			entry := w.queues[oldest][0]
+			w.queues[oldest][0] = asyncEntry{}
+			w.queues[oldest] = w.queues[oldest][1:]
+			w.count--
+			line = entry.line
+			w.writingSeq = entry.seq
+			w.writingEntry = true
+		} else if w.closed {
+			close(w.stopped)
+			w.cond.Broadcast()
+			return
+		} else {
+			// nothing was dropped
+			w.reportDue = false
+			continue
+		}
+
+		// write without holding the lock, so Write does not wait for the output
+		w.cond.Broadcast()
+		w.mu.Unlock()
+		_, err := w.out.Write(line)
+		w.mu.Lock()
+		if w.writingReport {
+			w.reports++
+		}
+		w.writingEntry = false
+		w.writingReport = false
+		if err != nil {
+			w.err = errors.Join(w.err, err)
+		}
+		w.cond.Broadcast()
+	}
+}
+
+// reportDropped periodically asks run to write the number of dropped entries.
+func (w *AsyncWriter) reportDropped() {
+	ticker := time.NewTicker(w.config.DropReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dropped > 0 {
+				w.reportDue = true
+				w.cond.Broadcast()
+			}
+			w.mu.Unlock()
+		case <-w.stopped:
+			return
+		}
+	}
+}
+
+// droppedWarningFormat is a WARNING entry that reports dropped entries.
+const droppedWarningFormat = `{"severity":"WARNING","time":"%s",` +
+	`"message":"gcpzap: dropped %d log entries because the buffer was full","dropped":%d}` + "\n"
+
+func droppedWarning(now time.Time, dropped int) []byte {
+	timestamp := now.UTC().Format(time.RFC3339Nano)
+	return []byte(fmt.Sprintf(droppedWarningFormat, timestamp, dropped, dropped))
+}
+
+// entrySeverity returns the rank of the severity written by the gcpzap encoder at the start of
+// line. Lines without a known severity have the lowest rank.
+func entrySeverity(line []byte) int {
+	const prefix = `{"severity":"`
+	if !bytes.HasPrefix(line, []byte(prefix)) {
+		return 0
+	}
+	severity := line[len(prefix):]
+	end := bytes.IndexByte(severity, '"')
+	if end < 0 {
+		return 0
+	}
+	for i, s := range logLevelSeverity {
+		if bytes.Equal(s, severity[:end]) {
+			return i + 1
+		}
+	}
+	return 0
+}
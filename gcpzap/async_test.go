@@ -0,0 +1,219 @@
+package gcpzap
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// blockingWriter records lines. Writes wait while it is blocked.
+type blockingWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	blocked sync.WaitGroup
+	started chan struct{}
+}
+
+func newBlockingWriter() *blockingWriter {
+	b := &blockingWriter{started: make(chan struct{}, 100)}
+	b.blocked.Add(1)
+	return b
+}
+
+func (b *blockingWriter) Write(p []byte) (int, error) {
+	b.started <- struct{}{}
+	b.blocked.Wait()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *blockingWriter) Sync() error {
+	return nil
+}
+
+func (b *blockingWriter) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSpace(b.buf.String()), "\n")
+}
+
+// fillAsyncWriter writes one entry that blocks in the output, then fills the buffer of size 2.
+func fillAsyncWriter(policy OverflowPolicy) (*blockingWriter, *zap.Logger, *AsyncWriter) {
+	out := newBlockingWriter()
+	w := NewAsyncWriter(out, AsyncWriterConfig{BufferSize: 2, Policy: policy})
+	logger := zap.New(NewCore(w, zapcore.DebugLevel))
+	logger.Info("writing")
+	<-out.started
+	logger.Info("info")
+	logger.Debug("debug")
+	return out, logger, w
+}
+
+func TestAsyncWriterDropLowestSeverity(t *testing.T) {
+	out, logger, w := fillAsyncWriter(OverflowDropLowestSeverity)
+	logger.Warn("warning")
+	logger.Debug("dropped debug")
+	out.blocked.Done()
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := out.lines()
+	expected := []string{`"writing"`, `"info"`, `"warning"`, `"dropped":2`}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines: %#v", len(expected), lines)
+	}
+	for i, e := range expected {
+		if !strings.Contains(lines[i], e) {
+			t.Errorf("line %d must contain %s: %#v", i, e, lines[i])
+		}
+	}
+	if !strings.HasPrefix(lines[3], `{"severity":"WARNING",`) {
+		t.Errorf("dropped entries must be reported at WARNING: %#v", lines[3])
+	}
+}
+
+func TestAsyncWriterDropNewest(t *testing.T) {
+	out, logger, w := fillAsyncWriter(OverflowDropNewest)
+	logger.Error("dropped error")
+	out.blocked.Done()
+
+	// Sync writes all entries and reports the dropped entry
+	err := w.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := out.lines()
+	if len(lines) != 4 || !strings.Contains(lines[2], `"debug"`) ||
+		!strings.Contains(lines[3], `"dropped":1`) {
+		t.Errorf("must drop the new entry: %#v", lines)
+	}
+	w.Close()
+}
+
+func TestAsyncWriterBlock(t *testing.T) {
+	out, logger, w := fillAsyncWriter(OverflowBlock)
+	written := make(chan struct{})
+	go func() {
+		logger.Warn("blocked")
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("Write must wait while the buffer is full")
+	case <-time.After(10 * time.Millisecond):
+	}
+	out.blocked.Done()
+	<-written
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := out.lines(); len(lines) != 4 || !strings.Contains(lines[3], `"blocked"`) {
+		t.Errorf("must write all entries: %#v", lines)
+	}
+	if _, err := w.Write([]byte("after close\n")); err == nil {
+		t.Error("Write after Close must fail")
+	}
+}
+
+// slowWriter records the number of lines and sleeps for each write.
+type slowWriter struct {
+	lines atomic.Int64
+}
+
+func (s *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(10 * time.Microsecond)
+	s.lines.Add(1)
+	return len(p), nil
+}
+
+func (s *slowWriter) Sync() error {
+	return nil
+}
+
+func TestAsyncWriterSyncWhileWriting(t *testing.T) {
+	out := &slowWriter{}
+	w := NewAsyncWriter(out, AsyncWriterConfig{BufferSize: 16})
+	defer w.Close()
+
+	// keep the buffer full: Sync must only wait for the entries written before it was called
+	stop := make(chan struct{})
+	defer close(stop)
+	for i := 0; i < 4; i++ {
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					w.Write([]byte("{}\n"))
+				}
+			}
+		}()
+	}
+	for out.lines.Load() < 100 {
+		time.Sleep(time.Millisecond)
+	}
+
+	synced := make(chan error)
+	go func() {
+		synced <- w.Sync()
+	}()
+	select {
+	case err := <-synced:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Sync must return while entries are written")
+	}
+}
+
+func TestAsyncWriterDropReportInterval(t *testing.T) {
+	out := newBlockingWriter()
+	w := NewAsyncWriter(out, AsyncWriterConfig{
+		BufferSize: 1, Policy: OverflowDropNewest, DropReportInterval: time.Millisecond,
+	})
+	defer w.Close()
+	w.Write([]byte("{}\n"))
+	<-out.started
+	w.Write([]byte("{}\n"))
+	w.Write([]byte("{}\n"))
+	out.blocked.Done()
+
+	// the dropped entry is reported without calling Sync
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		if strings.Contains(strings.Join(out.lines(), "\n"), `"dropped":1`) {
+			return
+		}
+	}
+	t.Errorf("dropped entries must be reported periodically: %#v", out.lines())
+}
+
+func TestEntrySeverity(t *testing.T) {
+	tests := []struct {
+		line     string
+		severity int
+	}{
+		{`{"severity":"DEBUG","message":"x"}`, 1},
+		{`{"severity":"WARNING"}`, 3},
+		{`{"severity":"EMERGENCY"}`, 7},
+		{`{"severity":"UNKNOWN"}`, 0},
+		{`{"message":"x"}`, 0},
+		{`{"severity":"`, 0},
+	}
+	for _, test := range tests {
+		if severity := entrySeverity([]byte(test.line)); severity != test.severity {
+			t.Errorf("entrySeverity(%#v)=%d; expected %d", test.line, severity, test.severity)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package gcpzap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/oauth2/google"
+)
+
+// DefaultCloudLoggingEndpoint is the Cloud Logging API method that writes log entries. See:
+// https://cloud.google.com/logging/docs/reference/v2/rest/v2/entries/write
+const DefaultCloudLoggingEndpoint = "https://logging.googleapis.com/v2/entries:write"
+
+// Defaults for CloudLoggingConfig.
+const (
+	DefaultFlushInterval = 5 * time.Second
+	DefaultBatchSize     = 500
+	DefaultMaxRetries    = 3
+	DefaultRetryDelay    = time.Second
+	DefaultQueueSize     = 10000
+)
+
+// The OAuth scope needed to write log entries.
+const cloudLoggingWriteScope = "https://www.googleapis.com/auth/logging.write"
+
+// maxBatchBytes limits the size of each request. The API's limit is 10 MB.
+const maxBatchBytes = 5 * 1024 * 1024
+
+// cloudLoggingRequestTimeout limits each attempt to send a batch.
+const cloudLoggingRequestTimeout = 30 * time.Second
+
+// ErrQueueFull is returned by CloudLoggingWriter.Write when the queue of entries waiting to be
+// sent is full. The entry is dropped.
+var ErrQueueFull = errors.New("gcpzap: Cloud Logging queue is full")
+
+// errWriterClosed is returned by Write after Close.
+var errWriterClosed = errors.New("gcpzap: writer is closed")
+
+// CloudLoggingConfig configures a CloudLoggingWriter. The zero value uses the defaults.
+type CloudLoggingConfig struct {
+	// ProjectID is the project to write logs to. If empty, it is found with
+	// gcplogs.ProjectIDResolver.
+	ProjectID string
+	// LogName is the log ID, such as "app". If empty, the name of the executable is used.
+	LogName string
+	// ResourceType and ResourceLabels are the monitored resource the logs are written for. If
+	// ResourceType is empty, it is detected with gcplogs.DetectPlatformWithMetadata.
+	ResourceType   string
+	ResourceLabels map[string]string
+
+	// Endpoint is the URL of the entries:write method. If empty, DefaultCloudLoggingEndpoint is
+	// used.
+	Endpoint string
+	// Client sends the requests. It must add credentials. If nil, it uses application default
+	// credentials from google.DefaultClient.
+	Client *http.Client
+
+	// FlushInterval is the maximum time entries wait before they are sent. If zero,
+	// DefaultFlushInterval is used.
+	FlushInterval time.Duration
+	// BatchSize is the maximum number of entries in each request. If zero, DefaultBatchSize is
+	// used.
+	BatchSize int
+	// MaxRetries is the number of times a request that failed with a temporary error is retried.
+	// If zero, DefaultMaxRetries is used. If negative, requests are not retried.
+	MaxRetries int
+	// RetryDelay is the time to wait before the first retry. It doubles for each retry. If zero,
+	// DefaultRetryDelay is used.
+	RetryDelay time.Duration
+	// QueueSize is the maximum number of entries waiting to be sent. Write drops entries and
+	// returns ErrQueueFull when it is full. If zero, DefaultQueueSize is used.
+	QueueSize int
+}
+
+// CloudLoggingWriter is a zapcore.WriteSyncer that sends entries written by the gcpzap encoder to
+// the Cloud Logging API, for environments where nothing collects logs from stdout. Entries are
+// sent in batches by a background goroutine. The special fields written by gcpzap, such as
+// severity and trace, are converted to LogEntry fields, and the others are the jsonPayload.
+// Call Close to send the remaining entries before exiting.
+type CloudLoggingWriter struct {
+	config   CloudLoggingConfig
+	logName  string
+	resource monitoredResource
+
+	queue   chan []byte
+	flushes chan chan struct{}
+	closing chan struct{}
+	stopped chan struct{}
+
+	// closeMu is held by Write while it queues an entry, and by Close, so run receives every entry
+	// that Write accepted before it stops
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu  sync.Mutex
+	err error
+}
+
+// cloudLoggingRequest is an encoded batch of entries, and its failed attempts.
+type cloudLoggingRequest struct {
+	body     []byte
+	entries  int
+	attempts int
+	delay    time.Duration
+}
+
+type monitoredResource struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// NewCloudLoggingWriter returns a CloudLoggingWriter, and starts the goroutine that sends entries.
+func NewCloudLoggingWriter(
+	ctx context.Context, config CloudLoggingConfig,
+) (*CloudLoggingWriter, error) {
+	if config.ProjectID == "" {
+		var report *gcplogs.ProjectIDReport
+		config.ProjectID, report = (&gcplogs.ProjectIDResolver{}).Resolve(ctx)
+		if config.ProjectID == "" {
+			return nil, fmt.Errorf("gcpzap: could not find the project ID: %w", report.Err())
+		}
+	}
+	if config.LogName == "" {
+		config.LogName = filepath.Base(os.Args[0])
+	}
+	if config.ResourceType == "" {
+		// the metadata server has the zone, instance and cluster the resource labels need
+		platform := gcplogs.DetectPlatformWithMetadata(ctx, nil)
+		platform.ProjectID = config.ProjectID
+		config.ResourceType, config.ResourceLabels = platform.MonitoredResource()
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = DefaultCloudLoggingEndpoint
+	}
+	if config.Client == nil {
+		var err error
+		config.Client, err = google.DefaultClient(ctx, cloudLoggingWriteScope)
+		if err != nil {
+			return nil, fmt.Errorf("gcpzap: could not find credentials: %w", err)
+		}
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+
+	w := &CloudLoggingWriter{
+		config:   config,
+		logName:  "projects/" + config.ProjectID + "/logs/" + url.PathEscape(config.LogName),
+		resource: monitoredResource{config.ResourceType, config.ResourceLabels},
+		queue:    make(chan []byte, config.QueueSize),
+		flushes:  make(chan chan struct{}),
+		closing:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Core returns NewCore(w, level, opts...).
+func (w *CloudLoggingWriter) Core(level zapcore.LevelEnabler, opts ...ConfigOption) zapcore.Core {
+	return NewCore(w, level, opts...)
+}
+
+// Write queues one encoded entry to be sent. It returns ErrQueueFull if the queue is full.
+func (w *CloudLoggingWriter) Write(p []byte) (int, error) {
+	// zap reuses p after Write returns
+	entry := make([]byte, len(p))
+	copy(entry, p)
+
+	w.closeMu.RLock()
+	defer w.closeMu.RUnlock()
+	if w.closed {
+		return 0, errWriterClosed
+	}
+	select {
+	case w.queue <- entry:
+		return len(p), nil
+	default:
+		return 0, ErrQueueFull
+	}
+}
+
+// Sync sends all queued entries, including retries. It returns the errors from sending entries
+// since the last call to Sync.
+func (w *CloudLoggingWriter) Sync() error {
+	done := make(chan struct{})
+	select {
+	case w.flushes <- done:
+		<-done
+	case <-w.stopped:
+	}
+	return w.takeErr()
+}
+
+// Close sends all queued entries and stops the background goroutine. It does not wait between
+// retries: a request waiting to be retried is sent once more, and failed requests are not retried.
+// Entries written after Close are dropped. It returns the errors from sending entries since the
+// last call to Sync.
+func (w *CloudLoggingWriter) Close() error {
+	w.closeMu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.closing)
+	}
+	w.closeMu.Unlock()
+	<-w.stopped
+	return w.takeErr()
+}
+
+func (w *CloudLoggingWriter) takeErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.err
+	w.err = nil
+	return err
+}
+
+func (w *CloudLoggingWriter) addErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = errors.Join(w.err, err)
+}
+
+// run sends batches of entries until Close is called. While a failed request waits to be retried,
+// it stops receiving entries, which wait in the queue.
+func (w *CloudLoggingWriter) run() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch [][]byte
+	batchBytes := 0
+	// retrying is the request waiting for retryTimer
+	var retrying *cloudLoggingRequest
+	var retryTimer *time.Timer
+	// flushed are the Sync calls waiting for retrying
+	var flushed []chan struct{}
+	closing := false
+
+	attempt := func(req *cloudLoggingRequest) {
+		if w.attempt(req, !closing) {
+			retrying = req
+			retryTimer = time.NewTimer(req.delay)
+		} else {
+			retrying = nil
+			retryTimer = nil
+		}
+	}
+	send := func() {
+		if len(batch) > 0 {
+			req := w.newRequest(batch)
+			if req != nil {
+				attempt(req)
+			}
+		}
+		batch = nil
+		batchBytes = 0
+	}
+	add := func(entry []byte) {
+		if len(batch) > 0 && batchBytes+len(entry) > maxBatchBytes {
+			send()
+		}
+		batch = append(batch, entry)
+		batchBytes += len(entry)
+		if len(batch) >= w.config.BatchSize {
+			send()
+		}
+	}
+	drain := func() {
+		for retrying == nil {
+			select {
+			case entry := <-w.queue:
+				add(entry)
+			default:
+				send()
+				return
+			}
+		}
+	}
+	flush := func() {
+		drain()
+		if retrying == nil {
+			for _, done := range flushed {
+				close(done)
+			}
+			flushed = nil
+		}
+	}
+
+	for {
+		queue := w.queue
+		var retryC <-chan time.Time
+		if retrying != nil {
+			queue = nil
+			retryC = retryTimer.C
+		}
+
+		select {
+		case entry := <-queue:
+			add(entry)
+		case <-ticker.C:
+			if retrying == nil {
+				send()
+			}
+		case <-retryC:
+			attempt(retrying)
+			if len(flushed) > 0 {
+				flush()
+			}
+		case done := <-w.flushes:
+			flushed = append(flushed, done)
+			flush()
+		case <-w.closing:
+			closing = true
+			if retrying != nil {
+				retryTimer.Stop()
+				w.attempt(retrying, false)
+				retrying = nil
+			}
+			flush()
+			return
+		}
+	}
+}
+
+// newRequest encodes a batch of entries. It returns nil if they could not be encoded.
+func (w *CloudLoggingWriter) newRequest(batch [][]byte) *cloudLoggingRequest {
+	entries := make([]map[string]any, len(batch))
+	for i, line := range batch {
+		entries[i] = newLogEntry(line)
+	}
+	body, err := json.Marshal(map[string]any{
+		"logName":        w.logName,
+		"resource":       w.resource,
+		"entries":        entries,
+		"partialSuccess": true,
+	})
+	if err != nil {
+		w.addErr(fmt.Errorf("gcpzap: could not encode %d entries: %w", len(batch), err))
+		return nil
+	}
+	return &cloudLoggingRequest{body: body, entries: len(batch)}
+}
+
+// attempt sends req. It returns true if it failed with a temporary error and should be retried
+// after req.delay. Otherwise, it records the error, if any.
+func (w *CloudLoggingWriter) attempt(req *cloudLoggingRequest, canRetry bool) bool {
+	retry, err := w.post(req.body)
+	if err == nil {
+		return false
+	}
+	if retry && canRetry && req.attempts < w.config.MaxRetries {
+		req.attempts++
+		if req.delay == 0 {
+			req.delay = w.config.RetryDelay
+		} else {
+			req.delay *= 2
+		}
+		return true
+	}
+	w.addErr(fmt.Errorf("gcpzap: could not write %d entries: %w", req.entries, err))
+	return false
+}
+
+// post sends one entries:write request. It returns true if the error is temporary.
+func (w *CloudLoggingWriter) post(body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cloudLoggingRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, w.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.config.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusOK {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= http.StatusInternalServerError
+	return retry, fmt.Errorf("status=%d body=%s", resp.StatusCode, bytes.TrimSpace(respBody))
+}
+
+// logEntryFields maps the keys written by gcpzap to LogEntry fields, like the logging agents. See:
+// https://cloud.google.com/logging/docs/structured-logging#special-payload-fields
+var logEntryFields = map[string]string{
+	"severity":                "severity",
+	"time":                    "timestamp",
+	gcplogs.TraceKey:          "trace",
+	gcplogs.SpanIDKey:         "spanId",
+	gcplogs.TraceSampledKey:   "traceSampled",
+	gcplogs.HTTPRequestKey:    "httpRequest",
+	gcplogs.LabelsKey:         "labels",
+	insertIDKey:               "insertId",
+	operationKey:              "operation",
+	gcplogs.SourceLocationKey: "sourceLocation",
+}
+
+// newLogEntry converts a line written by the gcpzap encoder to a LogEntry. Lines that are not JSON
+// objects are written as textPayload.
+func newLogEntry(line []byte) map[string]any {
+	var payload map[string]json.RawMessage
+	err := json.Unmarshal(line, &payload)
+	if err != nil {
+		return map[string]any{"textPayload": string(bytes.TrimSpace(line))}
+	}
+	entry := map[string]any{}
+	for key, field := range logEntryFields {
+		if value, ok := payload[key]; ok {
+			entry[field] = value
+			delete(payload, key)
+		}
+	}
+	entry["jsonPayload"] = payload
+	return entry
+}
@@ -0,0 +1,363 @@
+package gcpzap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
+)
+
+type writeRequest struct {
+	LogName  string
+	Resource struct {
+		Type   string
+		Labels map[string]string
+	}
+	Entries        []map[string]any
+	PartialSuccess bool
+}
+
+// fakeLoggingServer records entries:write requests. The next failures requests fail with status.
+type fakeLoggingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []writeRequest
+	failures int
+	status   int
+}
+
+func newFakeLoggingServer(t *testing.T) *fakeLoggingServer {
+	f := &fakeLoggingServer{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.failures > 0 {
+			f.failures--
+			http.Error(w, "failed", f.status)
+			return
+		}
+		var req writeRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			t.Error(err)
+		}
+		f.requests = append(f.requests, req)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeLoggingServer) takeRequests() []writeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requests := f.requests
+	f.requests = nil
+	return requests
+}
+
+func newTestWriter(
+	t *testing.T, server *fakeLoggingServer, config CloudLoggingConfig,
+) *CloudLoggingWriter {
+	config.ProjectID = "projectid"
+	config.LogName = "test/log"
+	config.ResourceType = "global"
+	config.Endpoint = server.URL
+	config.Client = server.Client()
+	if config.RetryDelay == 0 {
+		config.RetryDelay = time.Millisecond
+	}
+	w, err := NewCloudLoggingWriter(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func TestCloudLoggingWriter(t *testing.T) {
+	server := newFakeLoggingServer(t)
+	w := newTestWriter(t, server, CloudLoggingConfig{BatchSize: 2})
+	logger := zap.New(w.Core(zap.InfoLevel, WithServiceContext("", ""), WithInsertID()))
+
+	tracer := &gcplogs.Tracer{ProjectID: "projectid"}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(gcplogs.TraceHeader, "traceid/123;o=1")
+	WithTraceCore(logger, tracer, r).Warn("first", zap.Int("key", 42), Label("label", "value"))
+	logger.Debug("disabled")
+	logger.Info("second")
+	logger.Info("third")
+	err := logger.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := server.takeRequests()
+	if len(requests) != 2 || len(requests[0].Entries) != 2 || len(requests[1].Entries) != 1 {
+		t.Fatalf("expected batches of 2 and 1 entries: %#v", requests)
+	}
+	req := requests[0]
+	if req.LogName != "projects/projectid/logs/test%2Flog" || req.Resource.Type != "global" ||
+		!req.PartialSuccess {
+		t.Errorf("invalid request: %#v", req)
+	}
+	entry := req.Entries[0]
+	expected := map[string]any{
+		"severity":     "WARNING",
+		"trace":        "projects/projectid/traces/traceid",
+		"spanId":       "000000000000007b",
+		"traceSampled": true,
+		"labels":       map[string]any{"label": "value"},
+		"jsonPayload":  map[string]any{"message": "first", "key": float64(42)},
+	}
+	for key, value := range expected {
+		if !jsonEqual(entry[key], value) {
+			t.Errorf("entry[%#v]=%#v; expected %#v", key, entry[key], value)
+		}
+	}
+	if entry["timestamp"] == nil || entry["insertId"] == nil {
+		t.Errorf("entry must have timestamp and insertId: %#v", entry)
+	}
+
+	// plain text lines are written as textPayload
+	w.Write([]byte("plain text\n"))
+	w.Sync()
+	requests = server.takeRequests()
+	if len(requests) != 1 || requests[0].Entries[0]["textPayload"] != "plain text" {
+		t.Errorf("expected textPayload: %#v", requests)
+	}
+}
+
+func TestCloudLoggingWriterResource(t *testing.T) {
+	// a Compute Engine VM, detected with the metadata server
+	for _, envVar := range []string{"K_SERVICE", "CLOUD_RUN_JOB", "GAE_SERVICE", "FUNCTION_TARGET",
+		"KUBERNETES_SERVICE_HOST"} {
+		t.Setenv(envVar, "")
+	}
+	metadataValues := map[string]string{
+		"/computeMetadata/v1/project/project-id": "metadata-project",
+		"/computeMetadata/v1/instance/zone":      "projects/123/zones/us-central1-a",
+		"/computeMetadata/v1/instance/id":        "4567",
+	}
+	metadataServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			value, ok := metadataValues[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Metadata-Flavor", "Google")
+			w.Write([]byte(value))
+		}))
+	defer metadataServer.Close()
+	t.Setenv(gcplogs.MetadataHostEnvVar, strings.TrimPrefix(metadataServer.URL, "http://"))
+
+	server := newFakeLoggingServer(t)
+	w, err := NewCloudLoggingWriter(context.Background(), CloudLoggingConfig{
+		ProjectID: "projectid", Endpoint: server.URL, Client: server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(`{"message":"m"}`))
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := server.takeRequests()
+	if len(requests) != 1 {
+		t.Fatalf("expected one request: %#v", requests)
+	}
+	expected := map[string]string{
+		"project_id": "projectid", "instance_id": "4567", "zone": "us-central1-a",
+	}
+	resource := requests[0].Resource
+	if resource.Type != "gce_instance" || !jsonEqual(resource.Labels, expected) {
+		t.Errorf("resource=%#v; expected gce_instance with labels %#v", resource, expected)
+	}
+}
+
+func TestCloudLoggingWriterFlushInterval(t *testing.T) {
+	server := newFakeLoggingServer(t)
+	w := newTestWriter(t, server, CloudLoggingConfig{FlushInterval: time.Millisecond})
+	w.Write([]byte(`{"message":"flushed"}`))
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		server.mu.Lock()
+		n := len(server.requests)
+		server.mu.Unlock()
+		if n > 0 {
+			return
+		}
+	}
+	t.Error("entries must be sent after FlushInterval without calling Sync")
+}
+
+func TestCloudLoggingWriterRetries(t *testing.T) {
+	server := newFakeLoggingServer(t)
+	w := newTestWriter(t, server, CloudLoggingConfig{MaxRetries: 2})
+
+	// temporary errors are retried
+	server.failures = 2
+	server.status = http.StatusServiceUnavailable
+	w.Write([]byte(`{"message":"retried"}`))
+	err := w.Sync()
+	if err != nil || len(server.takeRequests()) != 1 {
+		t.Errorf("temporary errors must be retried: %v", err)
+	}
+
+	// too many failures are returned by Sync
+	server.failures = 3
+	w.Write([]byte(`{"message":"failed"}`))
+	err = w.Sync()
+	if err == nil || !strings.Contains(err.Error(), "status=503") {
+		t.Errorf("Sync must return the error: %v", err)
+	}
+	if w.Sync() != nil {
+		t.Error("Sync must only return errors since the last Sync")
+	}
+
+	// permanent errors are not retried
+	server.failures = 2
+	server.status = http.StatusForbidden
+	w.Write([]byte(`{"message":"forbidden"}`))
+	err = w.Sync()
+	if err == nil || server.failures != 1 {
+		t.Errorf("permanent errors must not be retried: err=%v failures=%d", err, server.failures)
+	}
+}
+
+func TestCloudLoggingWriterCloseWhileRetrying(t *testing.T) {
+	server := newFakeLoggingServer(t)
+	server.failures = 1
+	server.status = http.StatusServiceUnavailable
+	w := newTestWriter(t, server, CloudLoggingConfig{
+		FlushInterval: time.Millisecond, RetryDelay: time.Hour,
+	})
+	w.Write([]byte(`{"message":"retried"}`))
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		server.mu.Lock()
+		failures := server.failures
+		server.mu.Unlock()
+		if failures == 0 {
+			break
+		}
+	}
+
+	// Close must not wait for the retry delay
+	closed := make(chan error)
+	go func() { closed <- w.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close must not wait for the retry delay")
+	}
+	if requests := server.takeRequests(); len(requests) != 1 {
+		t.Errorf("Close must send the entry waiting to be retried: %#v", requests)
+	}
+}
+
+func TestCloudLoggingWriterWriteWhileClosing(t *testing.T) {
+	server := newFakeLoggingServer(t)
+	w := newTestWriter(t, server, CloudLoggingConfig{})
+
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				_, err := w.Write([]byte(`{"message":"concurrent"}`))
+				if errors.Is(err, errWriterClosed) {
+					return
+				}
+				if err == nil {
+					accepted.Add(1)
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	sent := 0
+	for _, request := range server.takeRequests() {
+		sent += len(request.Entries)
+	}
+	if int64(sent) != accepted.Load() {
+		t.Errorf("every accepted entry must be sent: accepted=%d sent=%d", accepted.Load(), sent)
+	}
+}
+
+func TestCloudLoggingWriterQueue(t *testing.T) {
+	// block the first request so the queue fills up
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	var once sync.Once
+	entries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			close(started)
+			<-unblock
+		})
+		var req writeRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			t.Error(err)
+		}
+		entries += len(req.Entries)
+	}))
+	defer server.Close()
+	w := newTestWriter(t, &fakeLoggingServer{Server: server}, CloudLoggingConfig{QueueSize: 1})
+
+	w.Write([]byte(`{"message":"sending"}`))
+	sending := make(chan error)
+	go func() { sending <- w.Sync() }()
+	<-started
+	_, err := w.Write([]byte(`{"message":"queued"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write([]byte(`{"message":"dropped"}`))
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Write must return ErrQueueFull: %v", err)
+	}
+	close(unblock)
+	if err := <-sending; err != nil {
+		t.Fatal(err)
+	}
+
+	// Close sends queued entries; later writes fail
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries != 2 {
+		t.Errorf("expected the sending and queued entries; got %d", entries)
+	}
+	if _, err := w.Write([]byte(`{}`)); err == nil {
+		t.Error("Write after Close must fail")
+	}
+}
+
+func jsonEqual(a any, b any) bool {
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return string(aJSON) == string(bJSON)
+}
@@ -0,0 +1,49 @@
+package gcpzap
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
+)
+
+type loggerContextKey struct{}
+
+// NewContext returns a copy of ctx that carries logger. Use FromContext to retrieve it.
+func NewContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// FromContext returns the *zap.Logger stored in ctx by NewContext or Tracer.Middleware. If ctx does
+// not contain a logger, it returns the root logger from zap.L(), with the trace from
+// gcplogs.TraceFromContext if it is set. Use zap.ReplaceGlobals to set the root logger.
+func FromContext(ctx context.Context) *zap.Logger {
+	logger, ok := ctx.Value(loggerContextKey{}).(*zap.Logger)
+	if !ok {
+		return WithTraceFromContext(ctx, zap.L())
+	}
+	return logger
+}
+
+// WithTraceFromContext returns a *zap.Logger that will use the trace from
+// gcplogs.TraceFromContext(ctx), if it is set.
+func WithTraceFromContext(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	return withTraceContext(logger, gcplogs.TraceFromContext(ctx))
+}
+
+// SugarFromContext returns FromContext(ctx) as a *zap.SugaredLogger.
+func SugarFromContext(ctx context.Context) *zap.SugaredLogger {
+	return FromContext(ctx).Sugar()
+}
+
+// Middleware returns an http.Handler that stores the trace-scoped logger from t.FromRequest in the
+// request's context, then calls next. Handlers can retrieve it with FromContext(r.Context()). The
+// context also contains the trace for gcplogs.TraceFromContext.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.Tracer.ContextFromRequest(r)
+		ctx = NewContext(ctx, WithTraceFromContext(ctx, t.Logger))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
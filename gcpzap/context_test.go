@@ -0,0 +1,91 @@
+package gcpzap
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func newBufferLogger(t *testing.T, buf *bytes.Buffer) *zap.Logger {
+	cfg := NewProductionConfig().EncoderConfig
+	enc, err := newEncoder(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zap.New(zapcore.NewCore(enc, zapcore.AddSync(buf), zapcore.DebugLevel))
+}
+
+func TestFromContext(t *testing.T) {
+	// without a logger: must return the global logger
+	ctx := context.Background()
+	if FromContext(ctx) != zap.L() {
+		t.Error("FromContext must return zap.L() if ctx does not have a logger")
+	}
+
+	// with only a trace: the global logger with the trace
+	buf := &bytes.Buffer{}
+	logger := newBufferLogger(t, buf)
+	restoreGlobals := zap.ReplaceGlobals(logger)
+	defer restoreGlobals()
+	traceCtx := gcplogs.ContextWithTrace(ctx, gcplogs.TraceContext{Trace: "projects/p/traces/t"})
+	FromContext(traceCtx).Info("global message")
+	const expected = `"message":"global message","logging.googleapis.com/trace":"projects/p/traces/t"`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("log should contain %#v; %#v", expected, buf.String())
+	}
+
+	buf.Reset()
+	ctx = NewContext(ctx, logger)
+	if FromContext(ctx) != logger {
+		t.Error("FromContext must return the logger from NewContext")
+	}
+	SugarFromContext(ctx).Infow("sugar message", "key", 42)
+	if !strings.Contains(buf.String(), `"message":"sugar message","key":42`) {
+		t.Errorf("SugarFromContext must write to the logger: %#v", buf.String())
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tracer := &Tracer{gcplogs.Tracer{ProjectID: "projectid"}, newBufferLogger(t, buf)}
+
+	handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("handler message")
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(gcplogs.TraceHeader, "traceid/spanid")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	const expected = `"message":"handler message",` +
+		`"logging.googleapis.com/trace":"projects/projectid/traces/traceid"`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("log should contain %#v; %#v", expected, buf.String())
+	}
+
+	// the trace is also available to code that only has the context
+	buf.Reset()
+	handler = tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WithTraceFromContext(r.Context(), tracer.Logger).Info("goroutine message")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	const expectedGoroutine = `"message":"goroutine message",` +
+		`"logging.googleapis.com/trace":"projects/projectid/traces/traceid"`
+	if !strings.Contains(buf.String(), expectedGoroutine) {
+		t.Errorf("log should contain %#v; %#v", expectedGoroutine, buf.String())
+	}
+
+	// without a trace header: logs with the request-scoped logger, without a trace
+	buf.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(buf.String(), `"message":"goroutine message"}`) {
+		t.Errorf("log should not contain a trace: %#v", buf.String())
+	}
+}
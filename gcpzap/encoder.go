@@ -2,8 +2,11 @@ package gcpzap
 
 import (
 	"regexp"
+	"sync"
 	"time"
 
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
 	"go.uber.org/zap/buffer"
 	"go.uber.org/zap/zapcore"
 )
@@ -37,127 +40,246 @@ func encodeTime(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 // The following issue might make this unnecessary:
 // https://github.com/uber-go/zap/issues/514
 type encoder struct {
-	jsonEncoder zapcore.Encoder
+	// wrapped writes the entries: JSON, or console for development
+	wrapped zapcore.Encoder
+	// console is true for the development console encoder, which writes stack traces unchanged
+	console bool
+	opts    encoderOptions
+	cfg     *zapcore.EncoderConfig
+	// labels added with logger.With; never modified, since clones share it
+	labels labels
+	// special Cloud Logging fields added with logger.With, which are kept if an entry is truncated;
+	// never modified, since clones share it
+	special []zapcore.Field
 }
 
+// Keys and values for entries that Error Reporting parses as errors. See:
+// https://cloud.google.com/error-reporting/docs/formatting-error-messages#log-entry-examples
+const (
+	typeKey                = "@type"
+	reportedErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+	stackTraceKey          = "stack_trace"
+)
+
 // multiline pattern to match the function name line
 var functionNamePattern = regexp.MustCompile(`(?m)^(\S+)$`)
 
 func (s *encoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
-	if ent.Stack != "" {
+	// copy fields to a pooled slice so we never modify the caller's slice, without allocating
+	extra := entryFieldsPool.Get().(*entryFields)
+	defer extra.free()
+
+	// the stack trace is written before other fields, so it is not lost if the entry is truncated;
+	// the console encoder writes it unchanged on its own lines, after the fields
+	rewriteStack := ent.Stack != "" && !s.console
+	if rewriteStack && s.opts.reportedErrorEvent {
+		extra.fields = append(extra.fields,
+			zap.String(typeKey, reportedErrorEventType),
+			zap.String(stackTraceKey, ent.Message+"\n\n"+goStackTrace(ent.Stack)))
+		ent.Stack = ""
+	} else if rewriteStack {
 		// Make the message look like a real panic, so Stackdriver error reporting picks it up.
 		// This used to need the string "panic: " at the beginning, but no longer seems to need it!
 		// ent.Message = "panic: " + ent.Message + "\n\ngoroutine 1 [running]:\n"
-		ent.Message = ent.Message + "\n\ngoroutine 1 [running]:\n"
-		// Trial-and-error: On App Engine Standard go111 the () are needed after function calls
-		// zap does not add them, so hack it with a regexp
-		replaced := functionNamePattern.ReplaceAllString(ent.Stack, "$1(...)")
-		ent.Message += replaced
+		ent.Message = ent.Message + "\n\n" + goStackTrace(ent.Stack)
 		ent.Stack = ""
 	}
-	return s.jsonEncoder.EncodeEntry(ent, fields)
+
+	userFieldsStart := len(extra.fields)
+	extra.labels = append(extra.labels, s.labels...)
+	for _, field := range fields {
+		if l, ok := field.Interface.(label); ok && field.Type == zapcore.InlineMarshalerType {
+			extra.labels.set(l)
+		} else {
+			extra.fields = append(extra.fields, field)
+		}
+	}
+	userFieldsEnd := len(extra.fields)
+	if len(extra.labels) > 0 {
+		extra.fields = append(extra.fields, zap.Object(gcplogs.LabelsKey, &extra.labels))
+	}
+
+	if ent.Caller.Defined && s.cfg.CallerKey != "" {
+		extra.caller = ent.Caller
+		extra.fields = append(extra.fields,
+			zap.Object(gcplogs.SourceLocationKey, (*sourceLocation)(&extra.caller)))
+		ent.Caller = zapcore.EntryCaller{}
+	}
+	if ent.Level >= zapcore.ErrorLevel && s.opts.serviceContext.service != "" {
+		extra.fields = append(extra.fields, zap.Object(serviceContextKey, s.opts.serviceContext))
+	}
+	if s.opts.insertID {
+		extra.fields = append(extra.fields, zap.String(insertIDKey, nextInsertID()))
+	}
+
+	buf, err := s.wrapped.EncodeEntry(ent, extra.fields)
+	if err != nil || s.opts.maxEntrySize <= 0 || buf.Len() <= s.opts.maxEntrySize {
+		return buf, err
+	}
+	size := buf.Len()
+	buf.Free()
+	return s.encodeTruncated(ent, extra.fields, userFieldsStart, userFieldsEnd, size)
+}
+
+// sourceLocation writes a zap caller as a Cloud Logging LogEntrySourceLocation. It is a pointer
+// into entryFields so adding it as a field does not allocate.
+type sourceLocation zapcore.EntryCaller
+
+func (l *sourceLocation) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("file", l.File)
+	enc.AddInt("line", l.Line)
+	if l.Function != "" {
+		enc.AddString("function", l.Function)
+	}
+	return nil
+}
+
+// entryFields holds the fields passed to the JSON encoder for one entry. They are pooled to avoid
+// allocating a new slice for each entry.
+type entryFields struct {
+	fields []zapcore.Field
+	caller zapcore.EntryCaller
+	labels labels
+}
+
+var entryFieldsPool = sync.Pool{New: func() any { return &entryFields{} }}
+
+func (e *entryFields) free() {
+	// do not keep references to the fields' values
+	clear(e.fields)
+	e.fields = e.fields[:0]
+	e.caller = zapcore.EntryCaller{}
+	clear(e.labels)
+	e.labels = e.labels[:0]
+	entryFieldsPool.Put(e)
+}
+
+// goStackTrace returns zap's stack trace formatted like the output of a Go panic.
+func goStackTrace(stack string) string {
+	// Trial-and-error: On App Engine Standard go111 the () are needed after function calls
+	// zap does not add them, so hack it with a regexp
+	return goroutineHeader + functionNamePattern.ReplaceAllString(stack, "$1(...)")
+}
+
+// goroutineHeader starts the stack traces written by the encoder.
+const goroutineHeader = "goroutine 1 [running]:\n"
+
+// addSpecial records a field added with logger.With if it is a special Cloud Logging field.
+func (s *encoder) addSpecial(field zapcore.Field) {
+	s.special = append(s.special[:len(s.special):len(s.special)], field)
 }
 
 func (s *encoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
-	return s.jsonEncoder.AddArray(key, marshaler)
+	return s.wrapped.AddArray(key, marshaler)
 }
 
 func (s *encoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
-	return s.jsonEncoder.AddObject(key, marshaler)
+	if specialKeys[key] {
+		s.addSpecial(zap.Object(key, marshaler))
+	}
+	return s.wrapped.AddObject(key, marshaler)
 }
 
 func (s *encoder) AddBinary(key string, value []byte) {
-	s.jsonEncoder.AddBinary(key, value)
+	s.wrapped.AddBinary(key, value)
 }
 
 func (s *encoder) AddByteString(key string, value []byte) {
-	s.jsonEncoder.AddByteString(key, value)
+	s.wrapped.AddByteString(key, value)
 }
 
 func (s *encoder) AddBool(key string, value bool) {
-	s.jsonEncoder.AddBool(key, value)
+	if specialKeys[key] {
+		s.addSpecial(zap.Bool(key, value))
+	}
+	s.wrapped.AddBool(key, value)
 }
 
 func (s *encoder) AddComplex128(key string, value complex128) {
-	s.jsonEncoder.AddComplex128(key, value)
+	s.wrapped.AddComplex128(key, value)
 }
 
 func (s *encoder) AddComplex64(key string, value complex64) {
-	s.jsonEncoder.AddComplex64(key, value)
+	s.wrapped.AddComplex64(key, value)
 }
 
 func (s *encoder) AddDuration(key string, value time.Duration) {
-	s.jsonEncoder.AddDuration(key, value)
+	s.wrapped.AddDuration(key, value)
 }
 
 func (s *encoder) AddFloat64(key string, value float64) {
-	s.jsonEncoder.AddFloat64(key, value)
+	s.wrapped.AddFloat64(key, value)
 }
 
 func (s *encoder) AddFloat32(key string, value float32) {
-	s.jsonEncoder.AddFloat32(key, value)
+	s.wrapped.AddFloat32(key, value)
 }
 
 func (s *encoder) AddInt(key string, value int) {
-	s.jsonEncoder.AddInt(key, value)
+	s.wrapped.AddInt(key, value)
 }
 
 func (s *encoder) AddInt64(key string, value int64) {
-	s.jsonEncoder.AddInt64(key, value)
+	s.wrapped.AddInt64(key, value)
 }
 
 func (s *encoder) AddInt32(key string, value int32) {
-	s.jsonEncoder.AddInt32(key, value)
+	s.wrapped.AddInt32(key, value)
 }
 
 func (s *encoder) AddInt16(key string, value int16) {
-	s.jsonEncoder.AddInt16(key, value)
+	s.wrapped.AddInt16(key, value)
 }
 
 func (s *encoder) AddInt8(key string, value int8) {
-	s.jsonEncoder.AddInt8(key, value)
+	s.wrapped.AddInt8(key, value)
 }
 
 func (s *encoder) AddString(key string, value string) {
-	s.jsonEncoder.AddString(key, value)
+	if specialKeys[key] {
+		s.addSpecial(zap.String(key, value))
+	}
+	s.wrapped.AddString(key, value)
 }
 
 func (s *encoder) AddTime(key string, value time.Time) {
-	s.jsonEncoder.AddTime(key, value)
+	s.wrapped.AddTime(key, value)
 }
 
 func (s *encoder) AddUint(key string, value uint) {
-	s.jsonEncoder.AddUint(key, value)
+	s.wrapped.AddUint(key, value)
 }
 
 func (s *encoder) AddUint64(key string, value uint64) {
-	s.jsonEncoder.AddUint64(key, value)
+	s.wrapped.AddUint64(key, value)
 }
 
 func (s *encoder) AddUint32(key string, value uint32) {
-	s.jsonEncoder.AddUint32(key, value)
+	s.wrapped.AddUint32(key, value)
 }
 
 func (s *encoder) AddUint16(key string, value uint16) {
-	s.jsonEncoder.AddUint16(key, value)
+	s.wrapped.AddUint16(key, value)
 }
 
 func (s *encoder) AddUint8(key string, value uint8) {
-	s.jsonEncoder.AddUint8(key, value)
+	s.wrapped.AddUint8(key, value)
 }
 
 func (s *encoder) AddUintptr(key string, value uintptr) {
-	s.jsonEncoder.AddUintptr(key, value)
+	s.wrapped.AddUintptr(key, value)
 }
 
 func (s *encoder) AddReflected(key string, value interface{}) error {
-	return s.jsonEncoder.AddReflected(key, value)
+	return s.wrapped.AddReflected(key, value)
 }
 
 func (s *encoder) OpenNamespace(key string) {
-	s.jsonEncoder.OpenNamespace(key)
+	s.wrapped.OpenNamespace(key)
 }
 
 func (s *encoder) Clone() zapcore.Encoder {
-	return &encoder{s.jsonEncoder.Clone()}
+	clone := *s
+	clone.wrapped = s.wrapped.Clone()
+	return &clone
 }
@@ -5,6 +5,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
@@ -68,3 +70,64 @@ func TestEncodeTime(t *testing.T) {
 		t.Errorf("expected:%#v; got %#v", expected, out)
 	}
 }
+
+func TestEncodeSourceLocation(t *testing.T) {
+	cfg := NewProductionConfig().EncoderConfig
+	enc, err := newEncoder(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := zapcore.Entry{
+		Message: "message",
+		Caller: zapcore.EntryCaller{Defined: true, File: "/src/example/main.go", Line: 42,
+			Function: "main.main"},
+	}
+	fields := []zapcore.Field{zap.Int("key", 1)}
+	out, err := enc.EncodeEntry(entry, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = `{"severity":"INFO","time":"0001-01-01T00:00:00Z","message":"message","key":1,` +
+		`"logging.googleapis.com/sourceLocation":` +
+		`{"file":"/src/example/main.go","line":42,"function":"main.main"}}` + "\n"
+	if out.String() != expected {
+		t.Errorf("expected:%#v; got %#v", expected, out.String())
+	}
+	out.Free()
+	if len(fields) != 1 || cap(fields) != 1 {
+		t.Error("EncodeEntry must not modify the caller's fields")
+	}
+
+	// writing the caller must not allocate more than writing without it
+	encodeAllocs := func(entry zapcore.Entry) float64 {
+		return testing.AllocsPerRun(100, func() {
+			out, err := enc.EncodeEntry(entry, fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out.Free()
+		})
+	}
+	withCaller := encodeAllocs(entry)
+	entry.Caller = zapcore.EntryCaller{}
+	withoutCaller := encodeAllocs(entry)
+	if withCaller > withoutCaller {
+		t.Errorf("encoding with a caller: %v allocs; without: %v", withCaller, withoutCaller)
+	}
+
+	// without a CallerKey: does not write the caller
+	cfg.CallerKey = ""
+	enc, err = newEncoder(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Caller = zapcore.EntryCaller{Defined: true, File: "main.go", Line: 1}
+	out, err = enc.EncodeEntry(entry, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out.Bytes(), []byte(gcplogs.SourceLocationKey)) {
+		t.Errorf("must not write %s without a CallerKey: %#v",
+			gcplogs.SourceLocationKey, out.String())
+	}
+}
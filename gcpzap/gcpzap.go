@@ -2,32 +2,112 @@
 package gcpzap
 
 import (
+	"fmt"
 	"net/http"
+	"os"
+	"sync"
 
 	"github.com/evanj/gcplogs"
+	"github.com/evanj/gcplogs/internal/terminal"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 const encoderName = "stackdriver_json"
+const consoleEncoderName = "stackdriver_console"
 
+// encoderKey identifies a registered encoder.
+type encoderKey struct {
+	opts    encoderOptions
+	console bool
+}
+
+// zap only passes the EncoderConfig to registered encoders, so each distinct encoderOptions is
+// registered with its own name. encoderName and consoleEncoderName use the zero options.
+var encoderNamesMu sync.Mutex
+var encoderNames = map[encoderKey]string{}
+
+func init() {
+	registerEncoder(encoderKey{}, encoderName)
+	registerEncoder(encoderKey{console: true}, consoleEncoderName)
+}
+
+// registerEncoder registers the encoder for key as name. encoderNamesMu must be held, except in
+// init.
+func registerEncoder(key encoderKey, name string) {
+	err := zap.RegisterEncoder(name, func(cfg zapcore.EncoderConfig) (zapcore.Encoder, error) {
+		if key.console {
+			return newConsoleEncoder(cfg, key.opts), nil
+		}
+		return newEncoderWithOptions(cfg, key.opts), nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	encoderNames[key] = name
+}
+
+// registeredEncoderName returns the registered name of the encoder for opts, registering it the
+// first time it is used.
+func registeredEncoderName(opts encoderOptions, console bool) string {
+	encoderNamesMu.Lock()
+	defer encoderNamesMu.Unlock()
+	key := encoderKey{opts, console}
+	name := encoderNames[key]
+	if name == "" {
+		name = encoderName
+		if console {
+			name = consoleEncoderName
+		}
+		name = fmt.Sprintf("%s_%d", name, len(encoderNames))
+		registerEncoder(key, name)
+	}
+	return name
+}
+
+// newEncoder is the encoder registered as encoderName, with the zero options.
 func newEncoder(cfg zapcore.EncoderConfig) (zapcore.Encoder, error) {
-	return &encoder{zapcore.NewJSONEncoder(cfg)}, nil
+	return newEncoderWithOptions(cfg, encoderOptions{}), nil
+}
+
+func newEncoderWithOptions(cfg zapcore.EncoderConfig, opts encoderOptions) *encoder {
+	return &encoder{wrapped: zapcore.NewJSONEncoder(cfg), opts: opts, cfg: &cfg}
+}
+
+// newConsoleEncoder is the encoder for NewDevelopmentConfig. It writes the same fields as
+// newEncoderWithOptions with zap's console encoder, but leaves stack traces for it to write on
+// their own lines.
+func newConsoleEncoder(cfg zapcore.EncoderConfig, opts encoderOptions) *encoder {
+	return &encoder{wrapped: zapcore.NewConsoleEncoder(cfg), console: true, opts: opts, cfg: &cfg}
 }
 
 // NewProductionConfig wraps zap.NewProductionConfig with configuration that works on Google Cloud.
-func NewProductionConfig() zap.Config {
-	// register the encoder: ignore errors; TODO: handle errors?
-	_ = zap.RegisterEncoder(encoderName, newEncoder)
+// When running on Google Cloud, error entries include a serviceContext for Error Reporting with the
+// service and version: see WithServiceContext.
+// The caller is written as logging.googleapis.com/sourceLocation, so the log viewer can link to it.
+// Entries larger than DefaultMaxEntrySize are truncated.
+func NewProductionConfig(opts ...ConfigOption) zap.Config {
+	config, _ := newProductionConfig(opts)
+	return config
+}
+
+// newProductionConfig returns NewProductionConfig and the options for its encoder.
+func newProductionConfig(opts []ConfigOption) (zap.Config, encoderOptions) {
+	encoderOpts := encoderOptions{}
+	encoderOpts.serviceContext = defaultServiceContext(gcplogs.DetectPlatform())
+	encoderOpts.maxEntrySize = DefaultMaxEntrySize
+	for _, opt := range opts {
+		opt(&encoderOpts)
+	}
 
 	config := zap.NewProductionConfig()
-	config.Encoding = encoderName
+	config.Encoding = registeredEncoderName(encoderOpts, false)
 	config.EncoderConfig.LevelKey = "severity"
 	config.EncoderConfig.EncodeLevel = encodeLevel
 	config.EncoderConfig.TimeKey = "time"
 	config.EncoderConfig.MessageKey = "message"
 	config.EncoderConfig.EncodeTime = encodeTime
-	return config
+	return config, encoderOpts
 }
 
 // NewProduction wraps zap.NewProduction with configuration that works on Google Cloud.
@@ -36,13 +116,75 @@ func NewProduction(opts ...zap.Option) (*zap.Logger, error) {
 	return cfg.Build(opts...)
 }
 
-// WithTraceCore returns a *zap.Logger that will use the trace ID from r, if it is set.
+// NewDevelopmentConfig wraps zap.NewDevelopmentConfig with the same keys, severities, and options
+// as NewProductionConfig, so fields and queries work the same way. It uses zap's console encoder,
+// which writes the time, severity and message on one line followed by the fields as JSON, with
+// stack traces on the following lines. It logs DEBUG entries, and writes stack traces for WARNING
+// and above. Entries are not truncated unless WithMaxEntrySize is used, and the serviceContext is
+// only written if WithServiceContext is used.
+func NewDevelopmentConfig(opts ...ConfigOption) zap.Config {
+	encoderOpts := encoderOptions{}
+	for _, opt := range opts {
+		opt(&encoderOpts)
+	}
+
+	config := zap.NewDevelopmentConfig()
+	config.Encoding = registeredEncoderName(encoderOpts, true)
+	config.EncoderConfig.LevelKey = "severity"
+	config.EncoderConfig.EncodeLevel = encodeLevel
+	config.EncoderConfig.TimeKey = "time"
+	config.EncoderConfig.MessageKey = "message"
+	return config
+}
+
+// NewDevelopment wraps zap.NewDevelopment with NewDevelopmentConfig.
+func NewDevelopment(opts ...zap.Option) (*zap.Logger, error) {
+	cfg := NewDevelopmentConfig()
+	return cfg.Build(opts...)
+}
+
+// NewAuto returns NewProduction when running on Google Cloud, as detected by
+// gcplogs.DetectPlatform, or when stderr, where both write, is not a terminal, such as when logs
+// are collected or redirected to a file. Otherwise, it returns NewDevelopment, for people reading
+// the logs in a terminal.
+func NewAuto(opts ...zap.Option) (*zap.Logger, error) {
+	if useProduction(gcplogs.DetectPlatform().Kind, terminal.IsTerminal(os.Stderr)) {
+		return NewProduction(opts...)
+	}
+	return NewDevelopment(opts...)
+}
+
+func useProduction(kind gcplogs.PlatformKind, stderrIsTerminal bool) bool {
+	return kind != gcplogs.PlatformUnknown || !stderrIsTerminal
+}
+
+// NewCore returns a zapcore.Core that writes entries at level or above to ws, encoded like
+// NewProductionConfig(opts...). Use it to write to a CloudLoggingWriter or AsyncWriter.
+func NewCore(
+	ws zapcore.WriteSyncer, level zapcore.LevelEnabler, opts ...ConfigOption,
+) zapcore.Core {
+	config, encoderOpts := newProductionConfig(opts)
+	return zapcore.NewCore(newEncoderWithOptions(config.EncoderConfig, encoderOpts), ws, level)
+}
+
+// WithTraceCore returns a *zap.Logger that will use the trace ID, span ID and sampled flag from r,
+// if they are set.
 func WithTraceCore(logger *zap.Logger, tracer *gcplogs.Tracer, r *http.Request) *zap.Logger {
-	traceID := tracer.FromRequest(r)
-	if traceID == "" {
+	return withTraceContext(logger, tracer.TraceContextFromRequest(r))
+}
+
+func withTraceContext(logger *zap.Logger, traceContext gcplogs.TraceContext) *zap.Logger {
+	if traceContext.Trace == "" {
 		return logger
 	}
-	return logger.With(zap.String(gcplogs.TraceKey, traceID))
+	fields := []zap.Field{zap.String(gcplogs.TraceKey, traceContext.Trace)}
+	if traceContext.SpanID != "" {
+		fields = append(fields, zap.String(gcplogs.SpanIDKey, traceContext.SpanID))
+	}
+	if traceContext.SampledKnown {
+		fields = append(fields, zap.Bool(gcplogs.TraceSampledKey, traceContext.Sampled))
+	}
+	return logger.With(fields...)
 }
 
 // WithTrace returns a *zap.SugaredLogger that will use the trace ID from r, if it is set.
@@ -79,6 +79,90 @@ func TestNewProduction(t *testing.T) {
 	}
 }
 
+func TestNewDevelopment(t *testing.T) {
+	interceptor := interceptStderr(t)
+	defer interceptor.Close()
+
+	logger, err := NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("debug message", zap.Int("example", 42))
+	logger.Warn("warning message")
+	logger.Sync()
+
+	loggedString := interceptor.readAll()
+	for _, expected := range []string{
+		"\tDEBUG\tdebug message\t{\"example\": 42, \"logging.googleapis.com/sourceLocation\": ",
+		"gcpzap/gcpzap_test.go\", \"line\": ",
+		"\tWARNING\twarning message\t{\"logging.googleapis.com/sourceLocation\": ",
+		"\"function\": \"github.com/evanj/gcplogs/gcpzap.TestNewDevelopment\"}}\n" +
+			"github.com/evanj/gcplogs/gcpzap.TestNewDevelopment\n\t",
+	} {
+		if !strings.Contains(loggedString, expected) {
+			t.Errorf("log should contain %#v; %#v", expected, loggedString)
+		}
+	}
+	if strings.Contains(loggedString, goroutineHeader) {
+		t.Errorf("the stack trace must not be rewritten: %#v", loggedString)
+	}
+}
+
+func TestNewDevelopmentConfigOptions(t *testing.T) {
+	interceptor := interceptStderr(t)
+	defer interceptor.Close()
+
+	logger, err := NewDevelopmentConfig(WithServiceContext("service", "v1")).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.With(Label("key", "value")).Error("error message")
+	logger.Info("info message")
+	logger.Sync()
+
+	// the error entry is followed by its stack trace
+	var errorLine, infoLine string
+	for _, line := range strings.Split(interceptor.readAll(), "\n") {
+		if strings.Contains(line, "\tERROR\t") {
+			errorLine = line
+		} else if strings.Contains(line, "\tINFO\t") {
+			infoLine = line
+		}
+	}
+	for _, expected := range []string{
+		"\"logging.googleapis.com/labels\": {\"key\": \"value\"}",
+		"\"serviceContext\": {\"service\": \"service\", \"version\": \"v1\"}",
+	} {
+		if !strings.Contains(errorLine, expected) {
+			t.Errorf("error entry should contain %#v; %#v", expected, errorLine)
+		}
+	}
+	if !strings.Contains(infoLine, "\tINFO\tinfo message\t") ||
+		strings.Contains(infoLine, "serviceContext") {
+		t.Errorf("info entry must not have a serviceContext: %#v", infoLine)
+	}
+}
+
+func TestNewAuto(t *testing.T) {
+	tests := []struct {
+		kind             gcplogs.PlatformKind
+		stderrIsTerminal bool
+		expected         bool
+	}{
+		{gcplogs.PlatformUnknown, true, false},
+		{gcplogs.PlatformUnknown, false, true},
+		{gcplogs.PlatformCloudRun, true, true},
+		{gcplogs.PlatformComputeEngine, false, true},
+	}
+	for _, test := range tests {
+		production := useProduction(test.kind, test.stderrIsTerminal)
+		if production != test.expected {
+			t.Errorf("useProduction(%#v, %t)=%t; expected %t",
+				test.kind, test.stderrIsTerminal, production, test.expected)
+		}
+	}
+}
+
 func TestWithTrace(t *testing.T) {
 	// replace stderr with a temporary file
 	interceptor := interceptStderr(t)
@@ -97,8 +181,33 @@ func TestWithTrace(t *testing.T) {
 
 	logString := interceptor.readAll()
 
-	const expected = `"logging.googleapis.com/trace":"projects/projectid/traces/traceid"`
+	const expected = `"logging.googleapis.com/trace":"projects/projectid/traces/traceid",`
 	if !strings.Contains(logString, expected) {
 		t.Errorf("log should contain %#v; %#v", expected, logString)
 	}
+	if strings.Contains(logString, gcplogs.TraceSampledKey) {
+		t.Errorf("log must not contain %s without a sampling decision: %#v",
+			gcplogs.TraceSampledKey, logString)
+	}
+
+	// a decision not to sample is written
+	r.Header.Set(gcplogs.TraceHeader, "traceid/123;o=0")
+	tracer.FromRequest(r).Info("message")
+	logString = interceptor.readAll()
+	const expectedNotSampled = `"logging.googleapis.com/spanId":"000000000000007b",` +
+		`"logging.googleapis.com/trace_sampled":false`
+	if !strings.Contains(logString, expectedNotSampled) {
+		t.Errorf("log should contain %#v; %#v", expectedNotSampled, logString)
+	}
+
+	// W3C traceparent with a span ID
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(gcplogs.TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	tracer.FromRequest(r).Info("message")
+	logString = interceptor.readAll()
+	const expectedSpan = `"logging.googleapis.com/trace":"projects/projectid/traces/4bf92f3577b34da6a3ce929d0e0e4736",` +
+		`"logging.googleapis.com/spanId":"00f067aa0ba902b7","logging.googleapis.com/trace_sampled":true`
+	if !strings.Contains(logString, expectedSpan) {
+		t.Errorf("log should contain %#v; %#v", expectedSpan, logString)
+	}
 }
@@ -0,0 +1,56 @@
+package gcpzap
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AccessLogMiddleware returns an http.Handler like Middleware, that also logs one entry for each
+// request with the Cloud Logging httpRequest field. The entry's severity is ERROR for 5xx
+// responses, WARNING for 4xx, and INFO otherwise. Use it where the platform does not write request
+// logs, such as Kubernetes Engine. trustedProxies selects the remote IP from X-Forwarded-For; use
+// gcplogs.LoadBalancerProxies behind Ingress or another external HTTP(S) load balancer. See
+// gcplogs.RemoteIP.
+func (t *Tracer) AccessLogMiddleware(next http.Handler, trustedProxies int) http.Handler {
+	return t.Middleware(gcplogs.RecordHTTPRequest(next, trustedProxies,
+		func(r *http.Request, httpRequest gcplogs.HTTPRequest) {
+			logger := FromContext(r.Context())
+			if ce := logger.Check(statusLevel(httpRequest.Status), "http request"); ce != nil {
+				ce.Write(HTTPRequest(httpRequest))
+			}
+		}))
+}
+
+// HTTPRequest returns a field that writes httpRequest as the Cloud Logging httpRequest field.
+func HTTPRequest(httpRequest gcplogs.HTTPRequest) zap.Field {
+	return zap.Object(gcplogs.HTTPRequestKey, httpRequestMarshaler(httpRequest))
+}
+
+type httpRequestMarshaler gcplogs.HTTPRequest
+
+func (h httpRequestMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	// the int64 fields are strings in the JSON encoding of the LogEntry protocol buffer
+	enc.AddString("requestMethod", h.Method)
+	enc.AddString("requestUrl", h.URL)
+	if h.RequestSize > 0 {
+		enc.AddString("requestSize", strconv.FormatInt(h.RequestSize, 10))
+	}
+	enc.AddInt("status", h.Status)
+	enc.AddString("responseSize", strconv.FormatInt(h.ResponseSize, 10))
+	enc.AddString("latency", strconv.FormatFloat(h.Latency.Seconds(), 'f', -1, 64)+"s")
+	addNonEmpty(enc, "userAgent", h.UserAgent)
+	addNonEmpty(enc, "remoteIp", h.RemoteIP)
+	addNonEmpty(enc, "referer", h.Referer)
+	addNonEmpty(enc, "protocol", h.Protocol)
+	return nil
+}
+
+func addNonEmpty(enc zapcore.ObjectEncoder, key string, value string) {
+	if value != "" {
+		enc.AddString(key, value)
+	}
+}
@@ -0,0 +1,59 @@
+package gcpzap
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evanj/gcplogs"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tracer := &Tracer{gcplogs.Tracer{ProjectID: "projectid"}, newBufferLogger(t, buf)}
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	handler := tracer.AccessLogMiddleware(notFound, 0)
+
+	r := httptest.NewRequest(http.MethodGet, "/path", nil)
+	r.Header.Set(gcplogs.TraceHeader, "traceid/123")
+	r.Header.Set("User-Agent", "agent")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var entry struct {
+		Severity    string
+		Message     string
+		Trace       string         `json:"logging.googleapis.com/trace"`
+		HTTPRequest map[string]any `json:"httpRequest"`
+	}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if entry.Severity != "WARNING" || entry.Message != "http request" ||
+		entry.Trace != "projects/projectid/traces/traceid" {
+		t.Errorf("wrong entry: %#v", buf.String())
+	}
+	expected := map[string]any{
+		"requestMethod": "GET",
+		"requestUrl":    "http://example.com/path",
+		"status":        float64(http.StatusNotFound),
+		"responseSize":  "10",
+		"userAgent":     "agent",
+		"remoteIp":      "192.0.2.1",
+		"protocol":      "HTTP/1.1",
+	}
+	for key, value := range expected {
+		if entry.HTTPRequest[key] != value {
+			t.Errorf("httpRequest.%s=%#v; expected %#v", key, entry.HTTPRequest[key], value)
+		}
+	}
+	latency, _ := entry.HTTPRequest["latency"].(string)
+	if !strings.HasSuffix(latency, "s") || len(latency) < 2 {
+		t.Errorf("invalid latency: %#v", entry.HTTPRequest["latency"])
+	}
+}
@@ -0,0 +1,33 @@
+package gcpzap
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+)
+
+// The key for the unique ID of a log entry. See:
+// https://cloud.google.com/logging/docs/structured-logging#special-payload-fields
+const insertIDKey = "logging.googleapis.com/insertId"
+
+// insertIDPrefix is unique for each process, so IDs from different instances do not collide.
+var insertIDPrefix = newInsertIDPrefix()
+
+// insertIDCounter is shared by all encoders, including clones, so IDs are never reused.
+var insertIDCounter atomic.Uint64
+
+func newInsertIDPrefix() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// nextInsertID returns a new unique insert ID. The counter is zero padded to the number of digits
+// in the maximum uint64, so IDs sort in the order they were created.
+func nextInsertID() string {
+	return fmt.Sprintf("%s-%020d", insertIDPrefix, insertIDCounter.Add(1))
+}
@@ -0,0 +1,73 @@
+package gcpzap
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestInsertID(t *testing.T) {
+	cfg := NewProductionConfig(WithInsertID())
+	cfg.Sampling = nil
+	logger, readAll := buildToFile(t, cfg)
+
+	// log concurrently with the logger and clones of its encoder
+	const goroutines = 4
+	const entries = 100
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			child := logger.With(zap.Int("goroutine", i))
+			for j := 0; j < entries; j++ {
+				if j%2 == 0 {
+					logger.Info("parent")
+				} else {
+					child.Info("child")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(readAll()), "\n")
+	if len(lines) != goroutines*entries {
+		t.Fatalf("expected %d lines; got %d", goroutines*entries, len(lines))
+	}
+	ids := map[string]bool{}
+	for _, line := range lines {
+		var entry map[string]any
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := entry[insertIDKey].(string)
+		if !strings.HasPrefix(id, insertIDPrefix+"-") || ids[id] {
+			t.Fatalf("invalid or duplicate insertId %#v: %#v", id, line)
+		}
+		ids[id] = true
+	}
+
+	// IDs sort in the order they were created
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+	next := nextInsertID()
+	if sorted[len(sorted)-1] >= next {
+		t.Errorf("new ID %#v must sort after all previous IDs", next)
+	}
+
+	// disabled by default
+	logger, readAll = buildToFile(t, NewProductionConfig())
+	logger.Info("message")
+	if out := readAll(); strings.Contains(out, insertIDKey) {
+		t.Errorf("must not contain %s by default: %#v", insertIDKey, out)
+	}
+}
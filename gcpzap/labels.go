@@ -0,0 +1,57 @@
+package gcpzap
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Label returns a field that the gcpzap encoder writes in the logging.googleapis.com/labels map,
+// instead of in the JSON payload. Labels can be added to loggers with logger.With. If a key is
+// added more than once, the last value is used. Other encoders write it as a string field.
+func Label(key string, value string) zap.Field {
+	return zap.Inline(label{key, value})
+}
+
+type label struct {
+	key   string
+	value string
+}
+
+// MarshalLogObject is called with the gcpzap encoder when a label is added with logger.With. The
+// encoder handles labels passed with each entry itself.
+func (l label) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if e, ok := enc.(*encoder); ok {
+		e.labels = e.labels.with(l)
+		return nil
+	}
+	enc.AddString(l.key, l.value)
+	return nil
+}
+
+type labels []label
+
+// set replaces the value of l.key, or appends it.
+func (ls *labels) set(l label) {
+	for i := range *ls {
+		if (*ls)[i].key == l.key {
+			(*ls)[i].value = l.value
+			return
+		}
+	}
+	*ls = append(*ls, l)
+}
+
+// with returns a copy of ls with l set, so ls can be shared by encoder clones.
+func (ls labels) with(l label) labels {
+	out := make(labels, len(ls), len(ls)+1)
+	copy(out, ls)
+	out.set(l)
+	return out
+}
+
+func (ls *labels) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, l := range *ls {
+		enc.AddString(l.key, l.value)
+	}
+	return nil
+}
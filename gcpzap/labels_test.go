@@ -0,0 +1,55 @@
+package gcpzap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLabel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := newBufferLogger(t, buf)
+
+	logger.Info("message", Label("key", "value"), zap.Int("field", 42))
+	const expected = `"message":"message","field":42,` +
+		`"logging.googleapis.com/labels":{"key":"value"}}`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("log should contain %#v; %#v", expected, buf.String())
+	}
+
+	// labels added with With are merged with the entry's labels; the last value wins
+	buf.Reset()
+	child := logger.With(Label("a", "with"), Label("b", "with"), zap.String("field", "with"))
+	child.Info("message", Label("b", "entry"), Label("c", "entry"))
+	const expectedWith = `"message":"message","field":"with",` +
+		`"logging.googleapis.com/labels":{"a":"with","b":"entry","c":"entry"}}`
+	if !strings.Contains(buf.String(), expectedWith) {
+		t.Errorf("log should contain %#v; %#v", expectedWith, buf.String())
+	}
+
+	// adding labels to a child does not change its parent or siblings
+	buf.Reset()
+	child.With(Label("a", "grandchild")).Info("grandchild")
+	child.Info("child")
+	logger.Info("parent")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 ||
+		!strings.Contains(lines[0], `{"a":"grandchild","b":"with"}`) ||
+		!strings.Contains(lines[1], `{"a":"with","b":"with"}`) ||
+		strings.Contains(lines[2], gcplogs.LabelsKey) {
+		t.Errorf("labels must not be shared: %#v", buf.String())
+	}
+
+	// other encoders write labels as ordinary fields
+	buf.Reset()
+	jsonLogger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{}),
+		zapcore.AddSync(buf), zapcore.DebugLevel))
+	jsonLogger.With(Label("a", "with")).Info("message", Label("b", "entry"))
+	if buf.String() != `{"a":"with","b":"entry"}`+"\n" {
+		t.Errorf("JSON encoder must write labels as fields: %#v", buf.String())
+	}
+}
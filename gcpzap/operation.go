@@ -0,0 +1,117 @@
+package gcpzap
+
+import (
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The key for the operation that a log entry is part of. See:
+// https://cloud.google.com/logging/docs/structured-logging#special-payload-fields
+const operationKey = "logging.googleapis.com/operation"
+
+// Operation is a *zap.Logger for a long-running operation. Its entries include
+// logging.googleapis.com/operation, so the log viewer groups them. The first entry is marked with
+// first: true, and End writes an entry marked with last: true.
+type Operation struct {
+	*zap.Logger
+	endLogger *zap.Logger
+}
+
+// StartOperation returns an Operation that writes entries to logger with the operation id and
+// producer. The id must be unique for the producer, which identifies the code or service that
+// started the operation. The first entry is the first one written by logger's core, after its
+// level checks and sampling.
+func StartOperation(logger *zap.Logger, id string, producer string) *Operation {
+	opLogger := logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &operationCore{core, id, producer, &atomic.Bool{}}
+	}))
+	return &Operation{opLogger, opLogger.WithOptions(zap.AddCallerSkip(1))}
+}
+
+// End writes an entry at InfoLevel that marks the end of the operation with last: true.
+func (o *Operation) End(msg string, fields ...zap.Field) {
+	end := zap.Field{Type: zapcore.SkipType, Interface: endOperation{}}
+	o.endLogger.Info(msg, append(fields[:len(fields):len(fields)], end)...)
+}
+
+// endOperation marks the entry written by End. Encoders ignore fields with zapcore.SkipType.
+type endOperation struct{}
+
+// operationCore adds the operation to each entry.
+type operationCore struct {
+	zapcore.Core
+	id       string
+	producer string
+	started  *atomic.Bool
+}
+
+func (c *operationCore) With(fields []zapcore.Field) zapcore.Core {
+	return &operationCore{c.Core.With(fields), c.id, c.producer, c.started}
+}
+
+// Check asks the wrapped core which of its cores write the entry, so their levels and sampling
+// apply, then adds an operationEntry that writes to them with the operation.
+func (c *operationCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	checked := c.Core.Check(ent, nil)
+	if checked == nil {
+		return ce
+	}
+	entry := &operationEntry{operationCore: c, checked: checked}
+	entry.outer = ce.AddCore(ent, entry)
+	return entry.outer
+}
+
+func (c *operationCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, c.withOperation(fields))
+}
+
+// withOperation returns a copy of fields with the operation.
+func (c *operationCore) withOperation(fields []zapcore.Field) []zapcore.Field {
+	op := operation{id: c.id, producer: c.producer, first: c.started.CompareAndSwap(false, true)}
+	for _, field := range fields {
+		if _, ok := field.Interface.(endOperation); ok && field.Type == zapcore.SkipType {
+			op.last = true
+		}
+	}
+	// copy fields so we never modify the caller's slice
+	return append(fields[:len(fields):len(fields)], zap.Object(operationKey, op))
+}
+
+// operationEntry writes one entry checked by the wrapped core, with the operation.
+type operationEntry struct {
+	*operationCore
+	// checked is the entry checked by the wrapped core
+	checked *zapcore.CheckedEntry
+	// outer is the entry checked by operationCore, which has the logger's ErrorOutput
+	outer *zapcore.CheckedEntry
+}
+
+func (e *operationEntry) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	// the logger adds the caller and stack after Check, and CheckedEntry.Write reports errors to
+	// ErrorOutput instead of returning them
+	e.checked.Entry = ent
+	e.checked.ErrorOutput = e.outer.ErrorOutput
+	e.checked.Write(e.withOperation(fields)...)
+	return nil
+}
+
+type operation struct {
+	id       string
+	producer string
+	first    bool
+	last     bool
+}
+
+func (o operation) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("id", o.id)
+	enc.AddString("producer", o.producer)
+	if o.first {
+		enc.AddBool("first", true)
+	}
+	if o.last {
+		enc.AddBool("last", true)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package gcpzap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestOperation(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc, err := newEncoder(NewProductionConfig().EncoderConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(zapcore.NewCore(enc, zapcore.AddSync(buf), zapcore.InfoLevel), zap.AddCaller())
+
+	op := StartOperation(logger, "import-1", "importer")
+	op.Debug("disabled")
+	op.Info("started")
+	op.With(zap.Int("batch", 2)).Info("batch")
+	op.End("done", zap.Int("rows", 3))
+	logger.Info("not part of the operation")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines: %#v", buf.String())
+	}
+	expected := []string{
+		`"message":"started",`,
+		`"logging.googleapis.com/operation":{"id":"import-1","producer":"importer","first":true}`,
+		`"message":"batch","batch":2,`,
+		`"logging.googleapis.com/operation":{"id":"import-1","producer":"importer"}`,
+		`"message":"done","rows":3,`,
+		`"logging.googleapis.com/operation":{"id":"import-1","producer":"importer","last":true}`,
+	}
+	for i, e := range expected {
+		if !strings.Contains(lines[i/2], e) {
+			t.Errorf("line %d must contain %#v: %#v", i/2, e, lines[i/2])
+		}
+	}
+	if !strings.Contains(lines[2], "operation_test.go") {
+		t.Errorf("End must report its caller: %#v", lines[2])
+	}
+	if strings.Contains(lines[3], operationKey) {
+		t.Errorf("the original logger must not be modified: %#v", lines[3])
+	}
+}
+
+func TestOperationTee(t *testing.T) {
+	enc, err := newEncoder(NewProductionConfig().EncoderConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoBuf := &bytes.Buffer{}
+	warnBuf := &bytes.Buffer{}
+	core := zapcore.NewTee(
+		zapcore.NewCore(enc, zapcore.AddSync(infoBuf), zapcore.InfoLevel),
+		zapcore.NewCore(enc.Clone(), zapcore.AddSync(warnBuf), zapcore.WarnLevel),
+	)
+	// the sampler drops all but the first entry with the same message
+	core = zapcore.NewSamplerWithOptions(core, time.Hour, 1, 0)
+
+	op := StartOperation(zap.New(core), "import-1", "importer")
+	op.Info("info")
+	op.Info("info")
+	op.Warn("warning")
+
+	if strings.Count(infoBuf.String(), "\n") != 2 {
+		t.Errorf("the sampler must drop the second info entry: %#v", infoBuf.String())
+	}
+	if !strings.Contains(infoBuf.String(), `"message":"info"`) ||
+		!strings.Contains(infoBuf.String(), `"first":true`) {
+		t.Errorf("the first entry must be the first one written: %#v", infoBuf.String())
+	}
+	lines := strings.Split(strings.TrimSpace(warnBuf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"message":"warning"`) ||
+		!strings.Contains(lines[0], operationKey) {
+		t.Errorf("the warning core must only write the warning: %#v", warnBuf.String())
+	}
+}
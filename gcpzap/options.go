@@ -0,0 +1,124 @@
+package gcpzap
+
+import (
+	"path"
+	"runtime/debug"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap/zapcore"
+)
+
+// ConfigOption customizes the encoder configured by NewProductionConfig.
+type ConfigOption func(*encoderOptions)
+
+// encoderOptions configures an encoder.
+type encoderOptions struct {
+	serviceContext     serviceContext
+	reportedErrorEvent bool
+	insertID           bool
+	maxEntrySize       int
+}
+
+// WithServiceContext sets the service and version written as serviceContext on error entries,
+// which Error Reporting uses to group errors. Empty strings disable it. The default is the service
+// and version from gcplogs.DetectPlatform, or the binary's build information on other Google Cloud
+// platforms, such as Kubernetes Engine. Use WithServiceContext(DefaultServiceContext()) to also use
+// the build information when not running on Google Cloud.
+func WithServiceContext(service string, version string) ConfigOption {
+	return func(o *encoderOptions) {
+		o.serviceContext = serviceContext{service, version}
+	}
+}
+
+// WithReportedErrorEvent writes entries with stack traces as Error Reporting ReportedErrorEvents:
+// the message is unchanged, and the stack trace is written in a separate stack_trace field. By
+// default, the stack trace is appended to the message so it looks like a panic.
+func WithReportedErrorEvent() ConfigOption {
+	return func(o *encoderOptions) {
+		o.reportedErrorEvent = true
+	}
+}
+
+// WithInsertID writes a unique logging.googleapis.com/insertId on each entry. Cloud Logging uses
+// it to remove duplicate entries, and to order entries with the same timestamp. The ID is a random
+// prefix chosen when the process starts, followed by a counter, so entries from one process sort in
+// the order they were written.
+func WithInsertID() ConfigOption {
+	return func(o *encoderOptions) {
+		o.insertID = true
+	}
+}
+
+// WithMaxEntrySize sets the maximum size of an encoded entry in bytes. Larger entries are
+// truncated: the largest fields are shortened first, then the message and stack trace, and the
+// entry records its original size in a truncated field. Zero disables the limit. The default is
+// DefaultMaxEntrySize.
+func WithMaxEntrySize(size int) ConfigOption {
+	return func(o *encoderOptions) {
+		o.maxEntrySize = size
+	}
+}
+
+// The key for Error Reporting's service context. See:
+// https://cloud.google.com/error-reporting/docs/formatting-error-messages#log-entry-examples
+const serviceContextKey = "serviceContext"
+
+// serviceContext identifies the service that reported an error.
+type serviceContext struct {
+	service string
+	version string
+}
+
+func (s serviceContext) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("service", s.service)
+	if s.version != "" {
+		enc.AddString("version", s.version)
+	}
+	return nil
+}
+
+// DefaultServiceContext returns the service and version for Error Reporting. It uses the Cloud Run
+// or Cloud Functions service and revision, App Engine service and version, or the Cloud Run job and
+// execution, from gcplogs.DetectPlatform. Otherwise it uses the name of the main package, and the
+// module version or version control revision from debug.ReadBuildInfo.
+func DefaultServiceContext() (service string, version string) {
+	platform := gcplogs.DetectPlatform()
+	if platform.Service != "" {
+		return platform.Service, platform.Version
+	}
+	return buildInfoServiceContext()
+}
+
+// defaultServiceContext returns the default for NewProductionConfig: the platform's service, or the
+// build information on other Google Cloud platforms. It is empty elsewhere, since the build
+// information names test binaries and tools.
+func defaultServiceContext(platform gcplogs.Platform) serviceContext {
+	if platform.Service != "" {
+		return serviceContext{platform.Service, platform.Version}
+	}
+	if platform.Kind == gcplogs.PlatformUnknown {
+		return serviceContext{}
+	}
+	service, version := buildInfoServiceContext()
+	return serviceContext{service, version}
+}
+
+// buildInfoServiceContext returns the name of the main package, and the module version or version
+// control revision from debug.ReadBuildInfo.
+func buildInfoServiceContext() (service string, version string) {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", ""
+	}
+	service = path.Base(buildInfo.Path)
+	version = buildInfo.Main.Version
+	if version == "" || version == "(devel)" {
+		version = ""
+		for _, setting := range buildInfo.Settings {
+			if setting.Key == "vcs.revision" {
+				version = setting.Value
+			}
+		}
+	}
+	return service, version
+}
@@ -0,0 +1,206 @@
+package gcpzap
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// buildToFile builds cfg to log to a file, and returns the logger and a function to read the file.
+func buildToFile(t *testing.T, cfg zap.Config) (*zap.Logger, func() string) {
+	outputPath := filepath.Join(t.TempDir(), "log.txt")
+	cfg.OutputPaths = []string{outputPath}
+	logger, err := cfg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger, func() string {
+		logger.Sync()
+		out, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+}
+
+func TestServiceContext(t *testing.T) {
+	t.Setenv("K_SERVICE", "")
+	t.Setenv("FUNCTION_TARGET", "")
+	t.Setenv("CLOUD_RUN_JOB", "")
+	t.Setenv("GAE_SERVICE", "appengine-service")
+	t.Setenv("GAE_VERSION", "appengine-version")
+	service, version := DefaultServiceContext()
+	if service != "appengine-service" || version != "appengine-version" {
+		t.Errorf("DefaultServiceContext()=%#v, %#v; expected the App Engine service", service, version)
+	}
+
+	logger, readAll := buildToFile(t, NewProductionConfig())
+	logger.Info("info message")
+	logger.Error("error message")
+	out := readAll()
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines: %#v", out)
+	}
+	const expected = `"serviceContext":{"service":"appengine-service","version":"appengine-version"}`
+	if strings.Contains(lines[0], serviceContextKey) {
+		t.Errorf("info entries must not have %s: %#v", serviceContextKey, lines[0])
+	}
+	if !strings.Contains(lines[1], expected) {
+		t.Errorf("error entries must contain %#v: %#v", expected, lines[1])
+	}
+
+	// override the default
+	logger, readAll = buildToFile(t, NewProductionConfig(WithServiceContext("custom", "")))
+	logger.Error("error message")
+	out = readAll()
+	if !strings.Contains(out, `"serviceContext":{"service":"custom"}`) {
+		t.Errorf("must use the custom service context: %#v", out)
+	}
+
+	// disable it
+	logger, readAll = buildToFile(t, NewProductionConfig(WithServiceContext("", "")))
+	logger.Error("error message")
+	out = readAll()
+	if strings.Contains(out, serviceContextKey) {
+		t.Errorf("must not contain %s: %#v", serviceContextKey, out)
+	}
+}
+
+func TestDefaultServiceContextBuildInfo(t *testing.T) {
+	t.Setenv("K_SERVICE", "")
+	t.Setenv("FUNCTION_TARGET", "")
+	t.Setenv("CLOUD_RUN_JOB", "")
+	t.Setenv("GAE_SERVICE", "")
+
+	// test binaries are named after the package
+	service, _ := DefaultServiceContext()
+	if service != "gcpzap.test" && service != "gcpzap" {
+		t.Errorf("DefaultServiceContext()=%#v; expected the test binary's package", service)
+	}
+
+	// the build information is not used by default when not on Google Cloud
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if gcplogs.DetectPlatform().Kind == gcplogs.PlatformUnknown {
+		logger, readAll := buildToFile(t, NewProductionConfig())
+		logger.Error("error message")
+		if out := readAll(); strings.Contains(out, serviceContextKey) {
+			t.Errorf("must not contain %s without a platform: %#v", serviceContextKey, out)
+		}
+	}
+
+	// it is used on Google Cloud platforms without a service name
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	logger, readAll := buildToFile(t, NewProductionConfig())
+	logger.Error("error message")
+	expected := `"serviceContext":{"service":"` + service
+	if out := readAll(); !strings.Contains(out, expected) {
+		t.Errorf("must contain %#v on Kubernetes Engine: %#v", expected, out)
+	}
+
+	tests := []struct {
+		platform gcplogs.Platform
+		expected serviceContext
+	}{
+		{gcplogs.Platform{}, serviceContext{}},
+		{gcplogs.Platform{Kind: gcplogs.PlatformComputeEngine}, serviceContext{service: service}},
+		{gcplogs.Platform{Kind: gcplogs.PlatformAppEngine, Service: "s", Version: "v"},
+			serviceContext{"s", "v"}},
+	}
+	for i, test := range tests {
+		// the version depends on how the test is built
+		output := defaultServiceContext(test.platform)
+		output.version = test.expected.version
+		if output != test.expected {
+			t.Errorf("%d: defaultServiceContext(%#v)=%#v; expected %#v",
+				i, test.platform, output, test.expected)
+		}
+	}
+}
+
+func TestReportedErrorEvent(t *testing.T) {
+	logger, readAll := buildToFile(t, NewProductionConfig(WithReportedErrorEvent()))
+	logger.Info("info message")
+	logger.Error("error message")
+	out := readAll()
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines: %#v", out)
+	}
+	if strings.Contains(lines[0], typeKey) || strings.Contains(lines[0], stackTraceKey) {
+		t.Errorf("entries without stack traces must not be error events: %#v", lines[0])
+	}
+
+	var entry map[string]any
+	err := json.Unmarshal([]byte(lines[1]), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry["message"] != "error message" {
+		t.Errorf("message must not be modified: %#v", entry["message"])
+	}
+	if entry[typeKey] != reportedErrorEventType {
+		t.Errorf("%s=%#v; expected %#v", typeKey, entry[typeKey], reportedErrorEventType)
+	}
+	stackTrace, _ := entry[stackTraceKey].(string)
+	if !strings.HasPrefix(stackTrace, "error message\n\ngoroutine 1 [running]:\n") ||
+		!strings.Contains(stackTrace, ".TestReportedErrorEvent(...)\n") {
+		t.Errorf("incorrect stack trace: %#v", stackTrace)
+	}
+	if _, ok := entry["stacktrace"]; ok {
+		t.Errorf("zap's stacktrace field must be removed: %#v", lines[1])
+	}
+}
+
+func TestConfigOptionsEncoder(t *testing.T) {
+	plain := NewProductionConfig()
+	withEvent := NewProductionConfig(WithReportedErrorEvent(), WithServiceContext("custom", ""))
+	if plain.Encoding == withEvent.Encoding {
+		t.Errorf("Encoding=%#v; different options must use different encoders", plain.Encoding)
+	}
+	if again := NewProductionConfig().Encoding; again != plain.Encoding {
+		t.Errorf("Encoding=%#v and %#v; the same options must use the same encoder",
+			plain.Encoding, again)
+	}
+	if development := NewDevelopmentConfig().Encoding; development == plain.Encoding {
+		t.Errorf("Encoding=%#v; development must use the console encoder", development)
+	}
+
+	plainLogger, readPlain := buildToFile(t, plain)
+	eventLogger, readEvent := buildToFile(t, withEvent)
+	plainLogger.Error("error message")
+	eventLogger.Error("error message")
+	if out := readPlain(); strings.Contains(out, stackTraceKey) {
+		t.Errorf("options must not be shared between configs: %#v", out)
+	}
+	if out := readEvent(); !strings.Contains(out, stackTraceKey) ||
+		!strings.Contains(out, `"serviceContext":{"service":"custom"}`) {
+		t.Errorf("the options must be used: %#v", out)
+	}
+
+	// the options do not depend on other EncoderConfig fields
+	withEvent.EncoderConfig.NewReflectedEncoder = func(w io.Writer) zapcore.ReflectedEncoder {
+		return json.NewEncoder(w)
+	}
+	logger, readAll := buildToFile(t, withEvent)
+	logger.Error("error message")
+	if out := readAll(); !strings.Contains(out, stackTraceKey) {
+		t.Errorf("the options must be used: %#v", out)
+	}
+
+	// the truncation default is kept
+	plain.EncoderConfig.NewReflectedEncoder = withEvent.EncoderConfig.NewReflectedEncoder
+	logger, readAll = buildToFile(t, plain)
+	logger.Info(strings.Repeat("x", DefaultMaxEntrySize))
+	if out := readAll(); len(out) > DefaultMaxEntrySize {
+		t.Errorf("entry must be truncated to %d bytes: %d bytes", DefaultMaxEntrySize, len(out))
+	}
+}
@@ -0,0 +1,53 @@
+package gcpzap
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewTransport returns an http.RoundTripper that propagates the trace from each request's context
+// like gcplogs.Transport, and logs each request with its method, URL, status and latency. The log
+// entries include the trace and the new child span. If base is nil, http.DefaultTransport is used.
+func NewTransport(logger *zap.Logger, base http.RoundTripper) *gcplogs.Transport {
+	return &gcplogs.Transport{
+		Base: base,
+		Observe: func(r *http.Request, resp *http.Response, err error, latency time.Duration) {
+			logClientRequest(WithTraceFromContext(r.Context(), logger), r, resp, err, latency)
+		},
+	}
+}
+
+func logClientRequest(
+	logger *zap.Logger, r *http.Request, resp *http.Response, err error, latency time.Duration,
+) {
+	fields := []zap.Field{
+		zap.String("method", r.Method),
+		zap.String("url", r.URL.String()),
+		zap.Duration("latency", latency),
+	}
+	level := zapcore.ErrorLevel
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	} else {
+		fields = append(fields, zap.Int("status", resp.StatusCode))
+		level = statusLevel(resp.StatusCode)
+	}
+	if ce := logger.Check(level, "http client request"); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// statusLevel returns the level to log an HTTP response with status.
+func statusLevel(status int) zapcore.Level {
+	if status >= http.StatusInternalServerError {
+		return zapcore.ErrorLevel
+	}
+	if status >= http.StatusBadRequest {
+		return zapcore.WarnLevel
+	}
+	return zapcore.InfoLevel
+}
@@ -0,0 +1,72 @@
+package gcpzap
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evanj/gcplogs"
+)
+
+func TestNewTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			http.Error(w, "error", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	buf := &bytes.Buffer{}
+	client := &http.Client{Transport: NewTransport(newBufferLogger(t, buf), nil)}
+
+	ctx := gcplogs.ContextWithTrace(context.Background(),
+		gcplogs.TraceContext{Trace: "projects/p/traces/4bf92f3577b34da6a3ce929d0e0e4736", Sampled: true})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/ok", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	logString := buf.String()
+	expected := `{"severity":"INFO",`
+	if !strings.HasPrefix(logString, expected) {
+		t.Errorf("log should start with %#v; %#v", expected, logString)
+	}
+	expected = `"message":"http client request",` +
+		`"logging.googleapis.com/trace":"projects/p/traces/4bf92f3577b34da6a3ce929d0e0e4736",` +
+		`"logging.googleapis.com/spanId":"`
+	if !strings.Contains(logString, expected) {
+		t.Errorf("log should contain %#v; %#v", expected, logString)
+	}
+	expected = `"method":"GET","url":"` + server.URL + `/ok","latency":`
+	if !strings.Contains(logString, expected) || !strings.Contains(logString, `"status":200}`) {
+		t.Errorf("log should contain %#v and status; %#v", expected, logString)
+	}
+
+	buf.Reset()
+	resp, err = client.Get(server.URL + "/error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.HasPrefix(buf.String(), `{"severity":"ERROR",`) || !strings.Contains(buf.String(), `"status":503`) {
+		t.Errorf("server errors must be logged as errors: %#v", buf.String())
+	}
+
+	buf.Reset()
+	server.Close()
+	_, err = client.Get(server.URL)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.HasPrefix(buf.String(), `{"severity":"ERROR",`) || !strings.Contains(buf.String(), `"error":`) {
+		t.Errorf("request errors must be logged: %#v", buf.String())
+	}
+}
@@ -0,0 +1,199 @@
+package gcpzap
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultMaxEntrySize is the default maximum size of an encoded entry. Cloud Logging drops entries
+// larger than 256 KiB, including the metadata it adds, so this leaves some room. See:
+// https://cloud.google.com/logging/quotas#log-limits
+const DefaultMaxEntrySize = 250 * 1024
+
+// The key for the original size of truncated entries.
+const truncatedKey = "truncated"
+
+type truncatedMarker struct {
+	originalSize int
+}
+
+func (t truncatedMarker) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("originalSize", t.originalSize)
+	return nil
+}
+
+// specialKeys are the Cloud Logging fields that are never shortened or removed from truncated
+// entries, since they are needed to find them.
+var specialKeys = map[string]bool{
+	gcplogs.TraceKey:          true,
+	gcplogs.SpanIDKey:         true,
+	gcplogs.TraceSampledKey:   true,
+	gcplogs.HTTPRequestKey:    true,
+	gcplogs.LabelsKey:         true,
+	gcplogs.SourceLocationKey: true,
+	operationKey:              true,
+	insertIDKey:               true,
+	serviceContextKey:         true,
+	typeKey:                   true,
+}
+
+// maxTruncateAttempts limits how many times a value is shortened. Each attempt removes the
+// remaining excess, which only needs to be repeated if escaping makes the value longer.
+const maxTruncateAttempts = 4
+
+// encodeTruncated encodes an entry that was originalSize bytes, which is larger than the maximum.
+// It replaces the largest of fields[userStart:userEnd] with shortened strings, then shortens the
+// message and stack trace, which are more useful. Special Cloud Logging fields such as the trace
+// are never shortened. If the entry is still too large because of fields added with logger.With,
+// it writes the shortened message with only the special fields. Since every attempt is encoded by
+// the JSON encoder, the output is always valid JSON.
+func (s *encoder) encodeTruncated(
+	ent zapcore.Entry, fields []zapcore.Field, userStart int, userEnd int, originalSize int,
+) (*buffer.Buffer, error) {
+	fields = append(fields, zap.Object(truncatedKey, truncatedMarker{originalSize}))
+	excess := 0
+	fits := func(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+		buf, err := s.wrapped.EncodeEntry(ent, fields)
+		if err != nil || buf.Len() <= s.opts.maxEntrySize {
+			return buf, err
+		}
+		excess = buf.Len() - s.opts.maxEntrySize
+		buf.Free()
+		return nil, nil
+	}
+	if buf, err := fits(ent, fields); buf != nil || err != nil {
+		return buf, err
+	}
+
+	// shorten the largest fields first
+	valueEncoder := s.newValueEncoder()
+	values := make([]string, userEnd-userStart)
+	order := make([]int, 0, len(values))
+	for i := range values {
+		field := fields[userStart+i]
+		if field.Type == zapcore.StringType {
+			// shorten the string itself: its encoded value would be escaped again
+			values[i] = field.String
+		} else {
+			values[i] = encodeValue(valueEncoder, field)
+		}
+		if values[i] != "" && !specialKeys[field.Key] {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(values[order[i]]) > len(values[order[j]])
+	})
+	for _, i := range order {
+		for attempt := 0; attempt < maxTruncateAttempts && values[i] != ""; attempt++ {
+			values[i] = truncateString(values[i], len(values[i])-excess)
+			fields[userStart+i] = zap.String(fields[userStart+i].Key, values[i])
+			if buf, err := fits(ent, fields); buf != nil || err != nil {
+				return buf, err
+			}
+		}
+	}
+
+	// then the message, keeping the stack trace that is appended to it by default
+	originalMessage := ent.Message
+	message, stack := ent.Message, ""
+	if !s.opts.reportedErrorEvent {
+		if i := strings.LastIndex(message, "\n\n"+goroutineHeader); i >= 0 {
+			message, stack = message[:i], message[i:]
+		}
+	}
+	for attempt := 0; attempt < maxTruncateAttempts && message != ""; attempt++ {
+		message = truncateString(message, len(message)-excess)
+		ent.Message = message + stack
+		if buf, err := fits(ent, fields); buf != nil || err != nil {
+			return buf, err
+		}
+	}
+
+	// then the stack trace, removing the last frames first
+	for attempt := 0; attempt < maxTruncateAttempts && stack != ""; attempt++ {
+		stack = truncateString(stack, len(stack)-excess)
+		ent.Message = message + stack
+		if buf, err := fits(ent, fields); buf != nil || err != nil {
+			return buf, err
+		}
+	}
+	for i := range fields {
+		if fields[i].Key != stackTraceKey || fields[i].Type != zapcore.StringType {
+			continue
+		}
+		for attempt := 0; attempt < maxTruncateAttempts && fields[i].String != ""; attempt++ {
+			fields[i].String = truncateString(fields[i].String, len(fields[i].String)-excess)
+			if buf, err := fits(ent, fields); buf != nil || err != nil {
+				return buf, err
+			}
+		}
+	}
+
+	// fields added with logger.With are too large: write the entry without them, except for the
+	// special fields, and the fields added by the encoder such as the labels and stack trace
+	kept := append([]zapcore.Field(nil), s.special...)
+	for i, field := range fields {
+		if i < userStart || i >= userEnd || specialKeys[field.Key] {
+			kept = append(kept, field)
+		}
+	}
+	ent.Message = truncateString(originalMessage, s.opts.maxEntrySize/8)
+	return zapcore.NewJSONEncoder(*s.cfg).EncodeEntry(ent, kept)
+}
+
+// newValueEncoder returns an encoder that only writes fields, to measure their encoded values.
+func (s *encoder) newValueEncoder() zapcore.Encoder {
+	cfg := *s.cfg
+	cfg.MessageKey = zapcore.OmitKey
+	cfg.LevelKey = zapcore.OmitKey
+	cfg.TimeKey = zapcore.OmitKey
+	cfg.NameKey = zapcore.OmitKey
+	cfg.CallerKey = zapcore.OmitKey
+	cfg.FunctionKey = zapcore.OmitKey
+	cfg.StacktraceKey = zapcore.OmitKey
+	cfg.LineEnding = "\n"
+	return zapcore.NewJSONEncoder(cfg)
+}
+
+// encodeValue returns the JSON encoding of field's value, or the empty string if field does not
+// have a single value that can be replaced.
+func encodeValue(valueEncoder zapcore.Encoder, field zapcore.Field) string {
+	switch field.Type {
+	case zapcore.SkipType, zapcore.NamespaceType, zapcore.InlineMarshalerType:
+		return ""
+	}
+	field.Key = ""
+	buf, err := valueEncoder.EncodeEntry(zapcore.Entry{}, []zapcore.Field{field})
+	if err != nil {
+		return ""
+	}
+	defer buf.Free()
+	// remove {"": and }\n
+	const prefix = `{"":`
+	const suffix = "}\n"
+	if buf.Len() < len(prefix)+len(suffix) {
+		return ""
+	}
+	return string(buf.Bytes()[len(prefix) : buf.Len()-len(suffix)])
+}
+
+// truncateString returns the first n bytes of s, without splitting a UTF-8 sequence.
+func truncateString(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
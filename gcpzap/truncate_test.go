@@ -0,0 +1,141 @@
+package gcpzap
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/evanj/gcplogs"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestTruncate(t *testing.T) {
+	const maxSize = 1000
+	buf := &bytes.Buffer{}
+	cfg := NewProductionConfig().EncoderConfig
+	newLogger := func(opts encoderOptions) *zap.Logger {
+		opts.maxEntrySize = maxSize
+		enc := newEncoderWithOptions(cfg, opts)
+		return zap.New(zapcore.NewCore(enc, zapcore.AddSync(buf), zapcore.DebugLevel))
+	}
+	// decode checks the last entry is valid JSON that fits, and returns it
+	decode := func(t *testing.T) map[string]any {
+		t.Helper()
+		line := buf.String()
+		buf.Reset()
+		if len(line) > maxSize {
+			t.Errorf("entry is %d bytes; limit %d", len(line), maxSize)
+		}
+		var entry map[string]any
+		err := json.Unmarshal([]byte(line), &entry)
+		if err != nil {
+			t.Fatalf("invalid JSON: %s: %#v", err, line)
+		}
+		return entry
+	}
+	logger := newLogger(encoderOptions{})
+
+	// small entries are not modified
+	logger.Info("small", zap.String("field", "value"))
+	entry := decode(t)
+	if _, ok := entry[truncatedKey]; ok || entry["field"] != "value" {
+		t.Errorf("small entry must not be truncated: %#v", entry)
+	}
+
+	// the largest fields are shortened first; the message and small fields are kept
+	large := strings.Repeat("\"quoted\" ", 200)
+	logger.Info("message", zap.String("small", "value"), zap.String("large", large))
+	entry = decode(t)
+	if entry["message"] != "message" || entry["small"] != "value" {
+		t.Errorf("the message and small fields must be kept: %#v", entry)
+	}
+	if shortened, _ := entry["large"].(string); !strings.HasPrefix(shortened, `"quoted"`) {
+		t.Errorf("large must be shortened: %#v", entry["large"])
+	}
+	marker, _ := entry[truncatedKey].(map[string]any)
+	if originalSize, _ := marker["originalSize"].(float64); originalSize <= maxSize {
+		t.Errorf("must record the original size: %#v", entry[truncatedKey])
+	}
+
+	// other values are replaced by their shortened JSON
+	logger.Info("message", zap.Any("object", map[string]string{"key": large}))
+	entry = decode(t)
+	if object, _ := entry["object"].(string); !strings.HasPrefix(object, `{"key":"\"quoted\"`) {
+		t.Errorf("object must be replaced by its shortened JSON: %#v", entry["object"])
+	}
+
+	// the message is shortened without splitting UTF-8 sequences
+	logger.Info(strings.Repeat("é", maxSize))
+	entry = decode(t)
+	message, _ := entry["message"].(string)
+	if !strings.HasPrefix(message, "éé") || !utf8.ValidString(message) || len(message) >= maxSize {
+		t.Errorf("invalid shortened message: %#v", message)
+	}
+
+	// the stack trace is kept ahead of fields
+	logger = newLogger(encoderOptions{reportedErrorEvent: true})
+	logger = logger.WithOptions(zap.AddStacktrace(zapcore.ErrorLevel))
+	logger.Error("error", zap.String("large", large))
+	entry = decode(t)
+	stackTrace, _ := entry[stackTraceKey].(string)
+	if !strings.Contains(stackTrace, "TestTruncate") || len(entry["large"].(string)) >= len(large) {
+		t.Errorf("stack trace must be kept: %#v", entry)
+	}
+
+	// by default the stack trace is in the message: it is kept when the message is shortened
+	logger = newLogger(encoderOptions{})
+	logger = logger.WithOptions(zap.AddStacktrace(zapcore.ErrorLevel))
+	logger.Error(strings.Repeat("m", maxSize))
+	entry = decode(t)
+	message, _ = entry["message"].(string)
+	if !strings.HasPrefix(message, "mmm") || !strings.Contains(message, "\n\n"+goroutineHeader) ||
+		!strings.Contains(message, "TestTruncate") {
+		t.Errorf("the stack trace must be kept: %#v", message)
+	}
+
+	// fields added with With are too large: writes the message and the special fields
+	const trace = "projects/p/traces/t"
+	logger.With(zap.String("with", strings.Repeat("x", 2*maxSize)),
+		zap.String(gcplogs.TraceKey, trace), Label("k", "v")).
+		Warn("with message", zap.String(gcplogs.SpanIDKey, "00f067aa0ba902b7"))
+	entry = decode(t)
+	if entry["message"] != "with message" || entry["severity"] != "WARNING" || entry["with"] != nil {
+		t.Errorf("must write the message without fields: %#v", entry)
+	}
+	labels, _ := entry[gcplogs.LabelsKey].(map[string]any)
+	if entry[gcplogs.TraceKey] != trace || entry[gcplogs.SpanIDKey] != "00f067aa0ba902b7" ||
+		labels["k"] != "v" || entry[truncatedKey] == nil {
+		t.Errorf("the trace, span ID, labels and truncated marker must be kept: %#v", entry)
+	}
+
+	// special fields are not shortened with the user fields
+	longTrace := "projects/p/traces/" + strings.Repeat("t", 300)
+	logger.Info("message", zap.String(gcplogs.TraceKey, longTrace), zap.String("large", large))
+	entry = decode(t)
+	if entry[gcplogs.TraceKey] != longTrace {
+		t.Errorf("the trace must not be shortened: %#v", entry)
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	tests := []struct {
+		input    string
+		n        int
+		expected string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 2, "he"},
+		{"hello", -1, ""},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+	}
+	for _, test := range tests {
+		output := truncateString(test.input, test.n)
+		if output != test.expected {
+			t.Errorf("truncateString(%#v, %d)=%#v; expected %#v", test.input, test.n, output, test.expected)
+		}
+	}
+}
@@ -1,18 +1,20 @@
 module github.com/evanj/gcplogs
 
-go 1.20
+go 1.21
 
 require (
 	go.uber.org/zap v1.24.0
-	golang.org/x/oauth2 v0.5.0
+	golang.org/x/oauth2 v0.20.0
+	google.golang.org/grpc v1.65.0
 )
 
 require (
-	cloud.google.com/go/compute/metadata v0.2.0 // indirect
-	github.com/golang/protobuf v1.5.2 // indirect
+	cloud.google.com/go/compute/metadata v0.3.0 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	golang.org/x/net v0.6.0 // indirect
-	google.golang.org/appengine v1.6.7 // indirect
-	google.golang.org/protobuf v1.28.0 // indirect
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240528184218-531527333157 // indirect
+	google.golang.org/protobuf v1.34.1 // indirect
 )
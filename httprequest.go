@@ -0,0 +1,165 @@
+package gcplogs
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPRequestKey is the log key for the HTTP request that a log entry describes. See:
+// https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#HttpRequest
+const HTTPRequestKey = "httpRequest"
+
+// HTTPRequest contains the fields of Cloud Logging's httpRequest special field.
+type HTTPRequest struct {
+	Method       string
+	URL          string
+	RequestSize  int64
+	Status       int
+	ResponseSize int64
+	Latency      time.Duration
+	UserAgent    string
+	RemoteIP     string
+	Referer      string
+	Protocol     string
+}
+
+// LoadBalancerProxies is the trustedProxies argument for servers behind a Google Cloud external
+// HTTP(S) load balancer, including Kubernetes Engine Ingress. It appends the client's address and
+// then its own to X-Forwarded-For. On Cloud Run and App Engine, the Google front end only appends
+// the client's address, so use 0.
+const LoadBalancerProxies = 1
+
+// NewHTTPRequest returns an HTTPRequest with the fields that are known before r is served. See
+// RemoteIP for trustedProxies.
+func NewHTTPRequest(r *http.Request, trustedProxies int) HTTPRequest {
+	url := r.URL.String()
+	if !r.URL.IsAbs() && r.Host != "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		url = scheme + "://" + r.Host + r.URL.RequestURI()
+	}
+
+	requestSize := r.ContentLength
+	if requestSize < 0 {
+		requestSize = 0
+	}
+	return HTTPRequest{
+		Method:      r.Method,
+		URL:         url,
+		RequestSize: requestSize,
+		UserAgent:   r.UserAgent(),
+		RemoteIP:    RemoteIP(r, trustedProxies),
+		Referer:     r.Referer(),
+		Protocol:    r.Proto,
+	}
+}
+
+// RemoteIP returns the address of the client that sent r. trustedProxies is the number of
+// addresses at the end of X-Forwarded-For that were appended by proxies in front of the server
+// after the client's address: 0 on Cloud Run and App Engine, or LoadBalancerProxies. The address
+// before them was appended by the first trusted proxy, and earlier addresses are set by the client,
+// so are not used. If X-Forwarded-For is not set or does not have enough addresses, the request did
+// not come through the proxies, so it returns the address that sent the request.
+func RemoteIP(r *http.Request, trustedProxies int) string {
+	var forwardedFor []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		forwardedFor = append(forwardedFor, strings.Split(value, ",")...)
+	}
+	if trustedProxies >= 0 && trustedProxies < len(forwardedFor) {
+		return strings.TrimSpace(forwardedFor[len(forwardedFor)-1-trustedProxies])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RecordHTTPRequest returns an http.Handler that calls next, then calls observe with the request
+// and an HTTPRequest that includes the response status, size, and latency. Use it to write access
+// logs. See RemoteIP for trustedProxies. The gcpzap package uses it to log requests.
+func RecordHTTPRequest(
+	next http.Handler, trustedProxies int, observe func(r *http.Request, httpRequest HTTPRequest),
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpRequest := NewHTTPRequest(r, trustedProxies)
+		recorder := &responseRecorder{ResponseWriter: w}
+		start := time.Now()
+		completed := false
+		// observe the request even if next panics: http.Server responds with a 500 error. This does
+		// not recover, so the panic's stack trace still starts in the handler.
+		defer func() {
+			httpRequest.Latency = time.Since(start)
+			httpRequest.Status = recorder.status
+			if httpRequest.Status == 0 && !completed {
+				httpRequest.Status = http.StatusInternalServerError
+			} else if httpRequest.Status == 0 {
+				httpRequest.Status = http.StatusOK
+			}
+			httpRequest.ResponseSize = recorder.size
+			observe(r, httpRequest)
+		}()
+		next.ServeHTTP(recorder, r)
+		completed = true
+	})
+}
+
+// responseRecorder records the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	// informational responses are followed by the real response
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher if the wrapped ResponseWriter does.
+func (w *responseRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, such as for WebSocket upgrades. It returns an error if the
+// wrapped ResponseWriter does not implement it.
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("gcplogs: %T does not implement http.Hijacker: %w",
+			w.ResponseWriter, http.ErrNotSupported)
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped ResponseWriter for http.ResponseController.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
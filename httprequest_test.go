@@ -0,0 +1,160 @@
+package gcplogs
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewHTTPRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/path?q=1", strings.NewReader("body"))
+	r.Host = "example.com"
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "agent")
+	r.Header.Set("Referer", "https://example.com/ref")
+	httpRequest := NewHTTPRequest(r, 0)
+	expected := HTTPRequest{
+		Method:      http.MethodPost,
+		URL:         "http://example.com/path?q=1",
+		RequestSize: 4,
+		UserAgent:   "agent",
+		RemoteIP:    "192.0.2.1",
+		Referer:     "https://example.com/ref",
+		Protocol:    "HTTP/1.1",
+	}
+	if httpRequest != expected {
+		t.Errorf("NewHTTPRequest()=%#v; expected %#v", httpRequest, expected)
+	}
+
+	r.TLS = &tls.ConnectionState{}
+	// the client can set earlier addresses; the last is added by the Google front end
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 198.51.100.7")
+	httpRequest = NewHTTPRequest(r, 0)
+	if httpRequest.URL != "https://example.com/path?q=1" || httpRequest.RemoteIP != "198.51.100.7" {
+		t.Errorf("URL=%#v RemoteIP=%#v; expected https and the last forwarded address",
+			httpRequest.URL, httpRequest.RemoteIP)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		forwardedFor   []string
+		trustedProxies int
+		expected       string
+	}{
+		{nil, 0, "192.0.2.1"},
+		{nil, LoadBalancerProxies, "192.0.2.1"},
+		{[]string{"198.51.100.7"}, 0, "198.51.100.7"},
+		{[]string{"10.0.0.1, 198.51.100.7"}, 0, "198.51.100.7"},
+		{[]string{"10.0.0.1, 198.51.100.7", "203.0.113.9"}, 0, "203.0.113.9"},
+		// an external HTTP(S) load balancer appends the client and its own address
+		{[]string{"198.51.100.7, 34.120.0.1"}, LoadBalancerProxies, "198.51.100.7"},
+		{[]string{"10.0.0.1,198.51.100.7,34.120.0.1"}, LoadBalancerProxies, "198.51.100.7"},
+		// not enough addresses: did not come through the load balancer
+		{[]string{"10.0.0.1"}, LoadBalancerProxies, "192.0.2.1"},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		for _, value := range test.forwardedFor {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		remoteIP := RemoteIP(r, test.trustedProxies)
+		if remoteIP != test.expected {
+			t.Errorf("%d: RemoteIP(%#v, %d)=%#v; expected %#v",
+				i, test.forwardedFor, test.trustedProxies, remoteIP, test.expected)
+		}
+	}
+}
+
+func TestRecordHTTPRequest(t *testing.T) {
+	tests := []struct {
+		handler      http.HandlerFunc
+		status       int
+		responseSize int64
+	}{
+		{func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0},
+		{func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) }, http.StatusOK, 5},
+		{func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not found", http.StatusNotFound)
+		}, http.StatusNotFound, 10},
+		{func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusAccepted)
+		}, http.StatusAccepted, 0},
+	}
+
+	for i, test := range tests {
+		var observed HTTPRequest
+		handler := RecordHTTPRequest(test.handler, 0, func(r *http.Request, httpRequest HTTPRequest) {
+			observed = httpRequest
+		})
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		if observed.Status != test.status || observed.ResponseSize != test.responseSize {
+			t.Errorf("%d: status=%d responseSize=%d; expected %d %d",
+				i, observed.Status, observed.ResponseSize, test.status, test.responseSize)
+		}
+		if observed.Latency <= 0 || observed.Method != http.MethodGet {
+			t.Errorf("%d: invalid request: %#v", i, observed)
+		}
+	}
+
+	// panics are recorded as errors, then propagated to the server
+	var observed HTTPRequest
+	handler := RecordHTTPRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}), 0, func(r *http.Request, httpRequest HTTPRequest) {
+		observed = httpRequest
+	})
+	func() {
+		defer func() {
+			if recover() != http.ErrAbortHandler {
+				t.Error("RecordHTTPRequest must propagate panics")
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	if observed.Status != http.StatusInternalServerError {
+		t.Errorf("status=%d; expected %d after a panic", observed.Status, http.StatusInternalServerError)
+	}
+}
+
+func TestRecordHTTPRequestHijack(t *testing.T) {
+	observed := make(chan HTTPRequest, 1)
+	handler := RecordHTTPRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+		rw.Flush()
+	}), 0, func(r *http.Request, httpRequest HTTPRequest) {
+		observed <- httpRequest
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("status=%d; expected the hijacked connection's response", resp.StatusCode)
+	}
+	if status := (<-observed).Status; status != http.StatusSwitchingProtocols {
+		t.Errorf("observed status=%d; expected %d", status, http.StatusSwitchingProtocols)
+	}
+
+	// ResponseRecorder does not implement Hijacker
+	recorder := &responseRecorder{ResponseWriter: httptest.NewRecorder()}
+	_, _, err = recorder.Hijack()
+	if !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("Hijack()=%v; expected ErrNotSupported", err)
+	}
+}
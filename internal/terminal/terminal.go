@@ -0,0 +1,10 @@
+// Package terminal detects terminals, to choose output for people or for log collection.
+package terminal
+
+import "os"
+
+// IsTerminal returns true if file is a terminal.
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
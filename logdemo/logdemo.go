@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -42,8 +43,9 @@ type stackdriverLine struct {
 	Severity string `json:"severity,omitempty"`
 	Message  string `json:"message,omitempty"`
 	TraceID  string `json:"logging.googleapis.com/trace,omitempty"`
-	// SpanID is documented but doesn't seem useful for anything?
+	// SpanID links the entry to the span in Cloud Trace, and is only useful with TraceID
 	SpanID           string        `json:"logging.googleapis.com/spanId,omitempty"`
+	TraceSampled     bool          `json:"logging.googleapis.com/trace_sampled,omitempty"`
 	Timestamp        *logTimestamp `json:"timestamp,omitempty"`
 	Time             string        `json:"time,omitempty"`
 	TimestampSeconds int64         `json:"timestampSeconds,omitempty"`
@@ -101,13 +103,16 @@ func (s *server) logDemo(w http.ResponseWriter, r *http.Request) {
 
 	output := io.MultiWriter(w, os.Stderr)
 	now := time.Now().UTC().Truncate(time.Millisecond).Add(987654)
-	traceID := s.tracer.FromRequest(r)
+	traceContext := s.tracer.TraceContextFromRequest(r)
+	traceID := traceContext.Trace
 
 	line := &stackdriverLine{
-		Severity:  "DEBUG",
-		Message:   "debug with timestamp struct field (works)",
-		TraceID:   traceID,
-		Timestamp: &logTimestamp{now.Unix(), now.Nanosecond()},
+		Severity:     "DEBUG",
+		Message:      "debug with timestamp struct field (works)",
+		TraceID:      traceID,
+		SpanID:       traceContext.SpanID,
+		TraceSampled: traceContext.Sampled,
+		Timestamp:    &logTimestamp{now.Unix(), now.Nanosecond()},
 	}
 	mustLogLine(output, line)
 
@@ -207,12 +212,13 @@ func main() {
 		log.Printf("Defaulting to port %s", port)
 	}
 
-	projectID := gcplogs.DefaultProjectID()
+	projectID, report := (&gcplogs.ProjectIDResolver{}).Resolve(context.Background())
 	if projectID == "" {
 		fmt.Fprintln(os.Stderr, "Could not find Google Project ID; Set "+gcplogs.ProjectEnvVar)
+		fmt.Fprintln(os.Stderr, report.Err())
 		os.Exit(1)
 	}
-	log.Printf("detected projectID:%s", projectID)
+	log.Printf("detected projectID:%s from source:%s", projectID, report.Source)
 
 	s := &server{gcplogs.Tracer{ProjectID: projectID}}
 	http.HandleFunc("/", rootHandler)
@@ -0,0 +1,129 @@
+package gcplogs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// MetadataHostEnvVar is the environment variable that overrides the metadata server's host. It is
+// also used by the official Google Cloud libraries and the metadata server emulators.
+const MetadataHostEnvVar = "GCE_METADATA_HOST"
+
+// The metadata server's IP address. The hostname metadata.google.internal requires a DNS lookup.
+const metadataDefaultHost = "169.254.169.254"
+
+// DefaultMetadataTimeout is the maximum time to wait for the metadata server. It normally responds
+// in a few milliseconds.
+const DefaultMetadataTimeout = 500 * time.Millisecond
+
+// Linux file that contains "Google" on Compute Engine and the environments built on it.
+const productNamePath = "/sys/class/dmi/id/product_name"
+
+// Environment variables set by serverless platforms that have a metadata server, but that might not
+// look like Compute Engine.
+var serverlessEnvVars = []string{"K_SERVICE", "CLOUD_RUN_JOB", "GAE_SERVICE", "FUNCTION_TARGET"}
+
+// MetadataServer reads values from the Compute Engine metadata server, which is also available on
+// Cloud Run, App Engine, Cloud Functions and Kubernetes Engine. See:
+// https://cloud.google.com/compute/docs/metadata/overview
+type MetadataServer struct {
+	// Host is the metadata server's host and optional port. If empty, it uses the
+	// GCE_METADATA_HOST environment variable, or the default metadata server address.
+	Host string
+
+	// Timeout is the maximum time for each request. If zero, it uses DefaultMetadataTimeout.
+	Timeout time.Duration
+
+	// Client sends the requests. If nil, it uses a client that does not use proxies.
+	Client *http.Client
+}
+
+// The metadata server must never be accessed through a proxy.
+var metadataClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: DefaultMetadataTimeout}).DialContext,
+	},
+}
+
+// Get returns the value at path, which is relative to /computeMetadata/v1/. For example:
+// project/project-id. If the host is not configured explicitly and this is not running on Google
+// Cloud, it returns an error without making a request, so it does not slow down startup.
+func (m *MetadataServer) Get(ctx context.Context, path string) (string, error) {
+	host := m.Host
+	if host == "" {
+		host = os.Getenv(MetadataHostEnvVar)
+	}
+	if host == "" {
+		if !systemSuggestsGCP() {
+			return "", fmt.Errorf("metadata server: not running on Google Cloud")
+		}
+		host = metadataDefaultHost
+	}
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = DefaultMetadataTimeout
+	}
+	client := m.Client
+	if client == nil {
+		client = metadataClient
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	url := "http://" + host + "/computeMetadata/v1/" + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("metadata server: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("metadata server: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server: %s returned status %d", url, resp.StatusCode)
+	}
+	if resp.Header.Get("Metadata-Flavor") != "Google" {
+		return "", fmt.Errorf("metadata server: %s is not a metadata server", url)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// ProjectID returns the project ID from the metadata server.
+func (m *MetadataServer) ProjectID(ctx context.Context) (string, error) {
+	return m.Get(ctx, "project/project-id")
+}
+
+// Returns true if this is probably running on Google Cloud: a serverless environment variable is
+// set, or the Linux product name file says so, like the official metadata package. On other
+// operating systems it returns false, so programs on laptops do not wait for the request. Set
+// GCE_METADATA_HOST to use the metadata server on other operating systems, such as Windows VMs.
+func systemSuggestsGCP() bool {
+	for _, envVar := range serverlessEnvVars {
+		if os.Getenv(envVar) != "" {
+			return true
+		}
+	}
+	return runtime.GOOS == "linux" && productNameSuggestsGCP()
+}
+
+// Returns true if the Linux product name file says this is running on Google Cloud.
+func productNameSuggestsGCP() bool {
+	productName, err := os.ReadFile(productNamePath)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(productName), "Google")
+}
@@ -0,0 +1,119 @@
+package gcplogs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newMetadataServer returns a fake metadata server that serves values.
+func newMetadataServer(t *testing.T, values map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		value, ok := values[strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Metadata-Flavor", "Google")
+		w.Write([]byte(value))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMetadataServer(t *testing.T) {
+	server := newMetadataServer(t, map[string]string{"project/project-id": "metadata-project\n"})
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	ctx := context.Background()
+	m := &MetadataServer{Host: host}
+	projectID, err := m.ProjectID(ctx)
+	if err != nil || projectID != "metadata-project" {
+		t.Errorf("ProjectID()=%#v, %v; expected metadata-project", projectID, err)
+	}
+
+	_, err = m.Get(ctx, "instance/zone")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing values must return an error: %v", err)
+	}
+
+	// uses the environment variable if Host is not set
+	t.Setenv(MetadataHostEnvVar, host)
+	projectID, err = (&MetadataServer{}).ProjectID(ctx)
+	if err != nil || projectID != "metadata-project" {
+		t.Errorf("ProjectID()=%#v, %v; expected metadata-project", projectID, err)
+	}
+}
+
+func TestMetadataServerNotMetadata(t *testing.T) {
+	// a server that does not set Metadata-Flavor is not a metadata server
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("captive portal"))
+	}))
+	defer server.Close()
+
+	m := &MetadataServer{Host: strings.TrimPrefix(server.URL, "http://")}
+	projectID, err := m.ProjectID(context.Background())
+	if err == nil || projectID != "" {
+		t.Errorf("ProjectID()=%#v, %v; expected an error", projectID, err)
+	}
+}
+
+func TestMetadataServerTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	const timeout = 10 * time.Millisecond
+	m := &MetadataServer{Host: strings.TrimPrefix(server.URL, "http://"), Timeout: timeout}
+	start := time.Now()
+	_, err := m.ProjectID(context.Background())
+	if err == nil {
+		t.Error("expected a timeout error")
+	}
+	if time.Since(start) > 50*timeout {
+		t.Errorf("request must time out after about %s; took %s", timeout, time.Since(start))
+	}
+}
+
+func TestMetadataServerNotOnGCP(t *testing.T) {
+	t.Setenv(MetadataHostEnvVar, "")
+	for _, envVar := range serverlessEnvVars {
+		t.Setenv(envVar, "")
+	}
+	if systemSuggestsGCP() {
+		t.Skip("this test must run outside Google Cloud")
+	}
+
+	// must fail immediately without a request
+	m := &MetadataServer{Client: &http.Client{Transport: failingTransport{t}}}
+	_, err := m.ProjectID(context.Background())
+	if err == nil {
+		t.Error("expected an error")
+	}
+
+	// serverless environments always have a metadata server
+	t.Setenv("K_SERVICE", "service")
+	if !systemSuggestsGCP() {
+		t.Error("K_SERVICE must indicate Cloud Run")
+	}
+}
+
+type failingTransport struct {
+	t *testing.T
+}
+
+func (f failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.t.Errorf("unexpected request: %s", r.URL)
+	return nil, http.ErrNotSupported
+}
@@ -0,0 +1,193 @@
+package gcplogs
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// PlatformKind identifies a Google Cloud runtime environment.
+type PlatformKind string
+
+// The platforms detected by DetectPlatform.
+const (
+	PlatformUnknown        PlatformKind = ""
+	PlatformCloudRun       PlatformKind = "cloud_run"
+	PlatformCloudRunJob    PlatformKind = "cloud_run_job"
+	PlatformAppEngine      PlatformKind = "app_engine"
+	PlatformCloudFunctions PlatformKind = "cloud_functions"
+	PlatformKubernetes     PlatformKind = "kubernetes_engine"
+	PlatformComputeEngine  PlatformKind = "compute_engine"
+)
+
+// Platform describes the Google Cloud environment the program is running in. The fields that are
+// not known are empty.
+type Platform struct {
+	Kind      PlatformKind
+	ProjectID string
+	// Service is the Cloud Run service or job, App Engine service, or Cloud Function name.
+	Service string
+	// Version is the Cloud Run revision or job execution, App Engine version, or Cloud Function
+	// version.
+	Version string
+	Region  string
+	Zone    string
+	// Instance is the App Engine or Compute Engine instance ID, or the Kubernetes pod name.
+	Instance string
+	// Cluster and Namespace are only set on Kubernetes Engine.
+	Cluster   string
+	Namespace string
+}
+
+// Path to the namespace of a Kubernetes pod.
+const kubernetesNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// DetectPlatform returns the platform from environment variables and local files. It does not make
+// any network requests, so it is fast, but some fields, such as Region, might be empty. The
+// environment variables are documented at:
+// https://cloud.google.com/run/docs/container-contract#env-vars
+// https://cloud.google.com/run/docs/container-contract#jobs-env-vars
+// https://cloud.google.com/appengine/docs/standard/go/runtime#environment_variables
+// https://cloud.google.com/functions/docs/configuring/env-var#runtime_environment_variables_set_automatically
+func DetectPlatform() Platform {
+	p := Platform{ProjectID: os.Getenv(ProjectEnvVar)}
+
+	switch {
+	case os.Getenv("FUNCTION_TARGET") != "":
+		// newer Cloud Functions runtimes are built on Cloud Run and also set K_SERVICE
+		p.Kind = PlatformCloudFunctions
+		p.Service = firstEnv("K_SERVICE", "FUNCTION_NAME")
+		p.Version = firstEnv("K_REVISION", "X_GOOGLE_FUNCTION_VERSION")
+		p.Region = os.Getenv("FUNCTION_REGION")
+	case os.Getenv("K_SERVICE") != "":
+		p.Kind = PlatformCloudRun
+		p.Service = os.Getenv("K_SERVICE")
+		p.Version = os.Getenv("K_REVISION")
+	case os.Getenv("CLOUD_RUN_JOB") != "":
+		p.Kind = PlatformCloudRunJob
+		p.Service = os.Getenv("CLOUD_RUN_JOB")
+		p.Version = os.Getenv("CLOUD_RUN_EXECUTION")
+	case os.Getenv("GAE_SERVICE") != "":
+		p.Kind = PlatformAppEngine
+		p.Service = os.Getenv("GAE_SERVICE")
+		p.Version = os.Getenv("GAE_VERSION")
+		p.Instance = os.Getenv("GAE_INSTANCE")
+	case os.Getenv("KUBERNETES_SERVICE_HOST") != "":
+		p.Kind = PlatformKubernetes
+		p.Instance, _ = os.Hostname()
+		namespace, err := os.ReadFile(kubernetesNamespacePath)
+		if err == nil {
+			p.Namespace = strings.TrimSpace(string(namespace))
+		}
+	case productNameSuggestsGCP():
+		p.Kind = PlatformComputeEngine
+	}
+	return p
+}
+
+// DetectPlatformWithMetadata returns DetectPlatform, with the empty fields filled from the metadata
+// server if it is available. It also detects Compute Engine on operating systems other than Linux.
+// If m is nil, it uses the defaults. Failed metadata requests are ignored.
+func DetectPlatformWithMetadata(ctx context.Context, m *MetadataServer) Platform {
+	if m == nil {
+		m = &MetadataServer{}
+	}
+	p := DetectPlatform()
+
+	projectID, err := m.ProjectID(ctx)
+	if err != nil {
+		// no metadata server: nothing else will work
+		return p
+	}
+	if p.Kind == PlatformUnknown {
+		p.Kind = PlatformComputeEngine
+	}
+	if p.ProjectID == "" {
+		p.ProjectID = projectID
+	}
+
+	// serverless environments have a region; others have a zone: projects/NUMBER/zones/ZONE
+	if p.Region == "" {
+		region, err := m.Get(ctx, "instance/region")
+		if err == nil {
+			p.Region = lastPathElement(region)
+		}
+	}
+	if p.Zone == "" {
+		zone, err := m.Get(ctx, "instance/zone")
+		if err == nil {
+			p.Zone = lastPathElement(zone)
+		}
+	}
+	if dashIndex := strings.LastIndexByte(p.Zone, '-'); p.Region == "" && dashIndex > 0 {
+		// the zone is the region with a suffix: us-central1-a
+		p.Region = p.Zone[:dashIndex]
+	}
+
+	if p.Kind == PlatformKubernetes && p.Cluster == "" {
+		p.Cluster, _ = m.Get(ctx, "instance/attributes/cluster-name")
+	}
+	if p.Instance == "" {
+		p.Instance, _ = m.Get(ctx, "instance/id")
+	}
+	return p
+}
+
+// MonitoredResource returns the Cloud Logging monitored resource type and labels for the
+// platform. Logs written with the Cloud Logging API should use it so they are shown with the logs
+// collected by the platform. See:
+// https://cloud.google.com/logging/docs/api/v2/resource-list
+func (p Platform) MonitoredResource() (string, map[string]string) {
+	labels := map[string]string{"project_id": p.ProjectID}
+	switch p.Kind {
+	case PlatformCloudRun:
+		labels["service_name"] = p.Service
+		labels["revision_name"] = p.Version
+		labels["location"] = p.Region
+		return "cloud_run_revision", labels
+	case PlatformCloudRunJob:
+		labels["job_name"] = p.Service
+		labels["location"] = p.Region
+		return "cloud_run_job", labels
+	case PlatformAppEngine:
+		labels["module_id"] = p.Service
+		labels["version_id"] = p.Version
+		labels["zone"] = p.Zone
+		return "gae_app", labels
+	case PlatformCloudFunctions:
+		labels["function_name"] = p.Service
+		labels["region"] = p.Region
+		return "cloud_function", labels
+	case PlatformKubernetes:
+		location := p.Zone
+		if location == "" {
+			location = p.Region
+		}
+		labels["location"] = location
+		labels["cluster_name"] = p.Cluster
+		labels["namespace_name"] = p.Namespace
+		labels["pod_name"] = p.Instance
+		return "k8s_pod", labels
+	case PlatformComputeEngine:
+		labels["instance_id"] = p.Instance
+		labels["zone"] = p.Zone
+		return "gce_instance", labels
+	default:
+		return "global", labels
+	}
+}
+
+// Returns the value of the first environment variable that is set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		value := os.Getenv(name)
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func lastPathElement(path string) string {
+	return path[strings.LastIndexByte(path, '/')+1:]
+}
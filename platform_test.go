@@ -0,0 +1,114 @@
+package gcplogs
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var platformEnvVars = []string{
+	ProjectEnvVar, "FUNCTION_TARGET", "FUNCTION_NAME", "FUNCTION_REGION", "X_GOOGLE_FUNCTION_VERSION",
+	"K_SERVICE", "K_REVISION", "CLOUD_RUN_JOB", "CLOUD_RUN_EXECUTION", "GAE_SERVICE", "GAE_VERSION",
+	"GAE_INSTANCE", "KUBERNETES_SERVICE_HOST", MetadataHostEnvVar,
+}
+
+func clearPlatformEnv(t *testing.T) {
+	for _, envVar := range platformEnvVars {
+		t.Setenv(envVar, "")
+	}
+}
+
+func TestDetectPlatform(t *testing.T) {
+	tests := []struct {
+		env      map[string]string
+		expected Platform
+	}{
+		{map[string]string{"K_SERVICE": "svc", "K_REVISION": "svc-001", ProjectEnvVar: "p"},
+			Platform{Kind: PlatformCloudRun, ProjectID: "p", Service: "svc", Version: "svc-001"}},
+		{map[string]string{"CLOUD_RUN_JOB": "job", "CLOUD_RUN_EXECUTION": "job-abc"},
+			Platform{Kind: PlatformCloudRunJob, Service: "job", Version: "job-abc"}},
+		{map[string]string{"GAE_SERVICE": "default", "GAE_VERSION": "v1", "GAE_INSTANCE": "i"},
+			Platform{Kind: PlatformAppEngine, Service: "default", Version: "v1", Instance: "i"}},
+		{map[string]string{"FUNCTION_TARGET": "Fn", "K_SERVICE": "fn", "K_REVISION": "3"},
+			Platform{Kind: PlatformCloudFunctions, Service: "fn", Version: "3"}},
+		{map[string]string{"FUNCTION_TARGET": "Fn", "FUNCTION_NAME": "fn", "FUNCTION_REGION": "us-east1",
+			"X_GOOGLE_FUNCTION_VERSION": "4"},
+			Platform{Kind: PlatformCloudFunctions, Service: "fn", Version: "4", Region: "us-east1"}},
+	}
+
+	for i, test := range tests {
+		clearPlatformEnv(t)
+		for key, value := range test.env {
+			t.Setenv(key, value)
+		}
+		output := DetectPlatform()
+		if output != test.expected {
+			t.Errorf("%d: DetectPlatform()=%#v; expected %#v", i, output, test.expected)
+		}
+	}
+
+	clearPlatformEnv(t)
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	output := DetectPlatform()
+	if output.Kind != PlatformKubernetes || output.Instance == "" {
+		t.Errorf("DetectPlatform()=%#v; expected Kubernetes with the pod name", output)
+	}
+}
+
+func TestDetectPlatformWithMetadata(t *testing.T) {
+	server := newMetadataServer(t, map[string]string{
+		"project/project-id":               "metadata-project",
+		"instance/id":                      "12345",
+		"instance/zone":                    "projects/123/zones/us-central1-a",
+		"instance/attributes/cluster-name": "cluster",
+	})
+	m := &MetadataServer{Host: strings.TrimPrefix(server.URL, "http://")}
+	ctx := context.Background()
+
+	clearPlatformEnv(t)
+	output := DetectPlatformWithMetadata(ctx, m)
+	expected := Platform{Kind: PlatformComputeEngine, ProjectID: "metadata-project", Region: "us-central1",
+		Zone: "us-central1-a", Instance: "12345"}
+	if output != expected {
+		t.Errorf("DetectPlatformWithMetadata()=%#v; expected %#v", output, expected)
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	output = DetectPlatformWithMetadata(ctx, m)
+	if output.Kind != PlatformKubernetes || output.Cluster != "cluster" || output.Zone != "us-central1-a" {
+		t.Errorf("DetectPlatformWithMetadata()=%#v; expected Kubernetes with the cluster", output)
+	}
+
+	// environment variables take priority
+	clearPlatformEnv(t)
+	t.Setenv("K_SERVICE", "svc")
+	t.Setenv(ProjectEnvVar, "env-project")
+	output = DetectPlatformWithMetadata(ctx, m)
+	if output.Kind != PlatformCloudRun || output.ProjectID != "env-project" || output.Region != "us-central1" {
+		t.Errorf("DetectPlatformWithMetadata()=%#v; expected Cloud Run with the region", output)
+	}
+
+	// no metadata server: the same as DetectPlatform
+	m.Host = "127.0.0.1:1"
+	output = DetectPlatformWithMetadata(ctx, m)
+	if output != DetectPlatform() {
+		t.Errorf("DetectPlatformWithMetadata()=%#v; expected %#v", output, DetectPlatform())
+	}
+}
+
+func TestMonitoredResource(t *testing.T) {
+	p := Platform{Kind: PlatformCloudRun, ProjectID: "p", Service: "svc", Version: "svc-001",
+		Region: "us-central1"}
+	resourceType, labels := p.MonitoredResource()
+	expected := map[string]string{"project_id": "p", "service_name": "svc", "revision_name": "svc-001",
+		"location": "us-central1"}
+	if resourceType != "cloud_run_revision" || !reflect.DeepEqual(labels, expected) {
+		t.Errorf("MonitoredResource()=%#v, %#v; expected cloud_run_revision, %#v", resourceType, labels, expected)
+	}
+
+	resourceType, labels = Platform{ProjectID: "p"}.MonitoredResource()
+	if resourceType != "global" || !reflect.DeepEqual(labels, map[string]string{"project_id": "p"}) {
+		t.Errorf("MonitoredResource()=%#v, %#v; expected global", resourceType, labels)
+	}
+}
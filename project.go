@@ -0,0 +1,166 @@
+package gcplogs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/oauth2/google"
+)
+
+// ProjectIDSource is one way to find the Google Cloud project ID.
+type ProjectIDSource struct {
+	// Name describes the source in a ProjectIDReport.
+	Name string
+
+	// ProjectID returns the project ID, or an error explaining why it could not find it.
+	ProjectID func(ctx context.Context) (string, error)
+}
+
+// EnvProjectIDSource returns the GOOGLE_CLOUD_PROJECT environment variable. It is set manually, by
+// App Engine, and by Cloud Shell.
+func EnvProjectIDSource() ProjectIDSource {
+	return ProjectIDSource{"env", func(ctx context.Context) (string, error) {
+		projectID := os.Getenv(ProjectEnvVar)
+		if projectID == "" {
+			return "", fmt.Errorf("%s is not set", ProjectEnvVar)
+		}
+		return projectID, nil
+	}}
+}
+
+// The environment variable with the path to the application default credentials file.
+const credentialsEnvVar = "GOOGLE_APPLICATION_CREDENTIALS"
+
+// CredentialsProjectIDSource returns the project ID from the application default credentials file:
+// GOOGLE_APPLICATION_CREDENTIALS, or the well-known file written by
+// `gcloud auth application-default login`. It contains a project ID when using a service account
+// key, but NOT with personal gcloud credentials. Unlike google.FindDefaultCredentials, it does not
+// use the metadata server; MetadataProjectIDSource does, with a timeout.
+func CredentialsProjectIDSource() ProjectIDSource {
+	return ProjectIDSource{"credentials", func(ctx context.Context) (string, error) {
+		path := os.Getenv(credentialsEnvVar)
+		if path == "" {
+			configDir, err := gcloudConfigDir()
+			if err != nil {
+				return "", err
+			}
+			path = filepath.Join(configDir, "application_default_credentials.json")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("application default credentials: %w", err)
+		}
+		creds, err := google.CredentialsFromJSON(ctx, data)
+		if err != nil {
+			return "", fmt.Errorf("application default credentials %s: %w", path, err)
+		}
+		if creds.ProjectID == "" {
+			return "", errors.New("application default credentials do not contain a project ID")
+		}
+		return creds.ProjectID, nil
+	}}
+}
+
+// MetadataProjectIDSource returns the project ID from the metadata server, which is available on
+// Compute Engine, Cloud Run, App Engine and Kubernetes Engine. If m is nil, it uses the defaults.
+func MetadataProjectIDSource(m *MetadataServer) ProjectIDSource {
+	if m == nil {
+		m = &MetadataServer{}
+	}
+	return ProjectIDSource{"metadata", m.ProjectID}
+}
+
+// GcloudProjectIDSource returns the default project configured for gcloud.
+func GcloudProjectIDSource() ProjectIDSource {
+	return ProjectIDSource{"gcloud", func(ctx context.Context) (string, error) {
+		projectID, err := gcloudConfigProjectID(ctx)
+		if err != nil {
+			return "", err
+		}
+		if projectID == "" {
+			return "", errors.New("gcloud does not have a default project")
+		}
+		return projectID, nil
+	}}
+}
+
+// DefaultProjectIDSources returns the sources used by DefaultProjectID, in order.
+func DefaultProjectIDSources() []ProjectIDSource {
+	return []ProjectIDSource{
+		EnvProjectIDSource(),
+		CredentialsProjectIDSource(),
+		MetadataProjectIDSource(nil),
+		GcloudProjectIDSource(),
+	}
+}
+
+// ProjectIDResolver finds the Google Cloud project ID by trying a list of sources in order.
+type ProjectIDResolver struct {
+	// Sources are tried in order until one returns a project ID. If nil, it uses
+	// DefaultProjectIDSources.
+	Sources []ProjectIDSource
+}
+
+// ProjectIDReport describes how ProjectIDResolver found the project ID.
+type ProjectIDReport struct {
+	// Source is the name of the source that returned the project ID, or empty if none did.
+	Source string
+
+	// Errors contains the errors from the sources that failed, in the order they were tried.
+	Errors []*ProjectIDSourceError
+}
+
+// Err returns an error describing why each source failed, or nil if no source failed.
+func (r *ProjectIDReport) Err() error {
+	errs := make([]error, len(r.Errors))
+	for i, err := range r.Errors {
+		errs[i] = err
+	}
+	return errors.Join(errs...)
+}
+
+// ProjectIDSourceError is the error from a ProjectIDSource.
+type ProjectIDSourceError struct {
+	Source string
+	Err    error
+}
+
+func (e *ProjectIDSourceError) Error() string {
+	return "project ID source " + e.Source + ": " + e.Err.Error()
+}
+
+func (e *ProjectIDSourceError) Unwrap() error {
+	return e.Err
+}
+
+// Resolve returns the project ID from the first source that has one, or the empty string if none
+// do. It stops early if ctx is done. The report is never nil.
+func (r *ProjectIDResolver) Resolve(ctx context.Context) (string, *ProjectIDReport) {
+	sources := r.Sources
+	if sources == nil {
+		sources = DefaultProjectIDSources()
+	}
+
+	report := &ProjectIDReport{}
+	for _, source := range sources {
+		if ctx.Err() != nil {
+			report.Errors = append(report.Errors, &ProjectIDSourceError{source.Name, ctx.Err()})
+			break
+		}
+
+		projectID, err := source.ProjectID(ctx)
+		if err == nil && projectID == "" {
+			err = errors.New("no project ID")
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, &ProjectIDSourceError{source.Name, err})
+			continue
+		}
+		report.Source = source.Name
+		return projectID, report
+	}
+	return "", report
+}
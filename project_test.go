@@ -0,0 +1,129 @@
+package gcplogs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func staticSource(name string, projectID string, err error) ProjectIDSource {
+	return ProjectIDSource{name, func(ctx context.Context) (string, error) {
+		return projectID, err
+	}}
+}
+
+func TestProjectIDResolver(t *testing.T) {
+	errFailed := errors.New("failed")
+	resolver := &ProjectIDResolver{[]ProjectIDSource{
+		staticSource("error", "", errFailed),
+		staticSource("empty", "", nil),
+		staticSource("custom", "custom-project", nil),
+		staticSource("never", "never-project", nil),
+	}}
+
+	projectID, report := resolver.Resolve(context.Background())
+	if projectID != "custom-project" || report.Source != "custom" {
+		t.Errorf("Resolve()=%#v, %#v; expected custom-project from custom", projectID, report.Source)
+	}
+	if len(report.Errors) != 2 || report.Errors[0].Source != "error" || report.Errors[1].Source != "empty" {
+		t.Fatalf("report must contain errors from the failed sources: %#v", report.Errors)
+	}
+	if !errors.Is(report.Err(), errFailed) {
+		t.Errorf("report.Err() must wrap the source errors: %v", report.Err())
+	}
+	const expected = "project ID source error: failed\nproject ID source empty: no project ID"
+	if report.Err().Error() != expected {
+		t.Errorf("report.Err()=%#v; expected %#v", report.Err().Error(), expected)
+	}
+
+	// no sources succeed
+	resolver.Sources = resolver.Sources[:2]
+	projectID, report = resolver.Resolve(context.Background())
+	if projectID != "" || report.Source != "" || len(report.Errors) != 2 {
+		t.Errorf("Resolve()=%#v, %#v; expected no project", projectID, report)
+	}
+
+	// no errors
+	resolver.Sources = []ProjectIDSource{staticSource("custom", "custom-project", nil)}
+	_, report = resolver.Resolve(context.Background())
+	if report.Err() != nil {
+		t.Errorf("report.Err() must be nil if no sources failed: %v", report.Err())
+	}
+}
+
+func TestProjectIDResolverCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	resolver := &ProjectIDResolver{[]ProjectIDSource{
+		{"cancels", func(ctx context.Context) (string, error) {
+			cancel()
+			return "", ctx.Err()
+		}},
+		staticSource("never", "never-project", nil),
+	}}
+
+	projectID, report := resolver.Resolve(ctx)
+	if projectID != "" || len(report.Errors) != 2 || !errors.Is(report.Errors[1].Err, context.Canceled) {
+		t.Errorf("Resolve()=%#v, %#v; must stop when the context is canceled", projectID, report.Err())
+	}
+}
+
+func TestDefaultProjectIDSources(t *testing.T) {
+	t.Setenv(ProjectEnvVar, "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "/does/not/exist.json")
+	t.Setenv(gcloudProjectEnvVar, "gcloud-env-project")
+	t.Setenv(MetadataHostEnvVar, "")
+	if systemSuggestsGCP() {
+		t.Skip("this test must run outside Google Cloud")
+	}
+
+	projectID, report := (&ProjectIDResolver{}).Resolve(context.Background())
+	if projectID != "gcloud-env-project" || report.Source != "gcloud" {
+		t.Errorf("Resolve()=%#v, %#v; expected the gcloud project", projectID, report.Source)
+	}
+	if len(report.Errors) != 3 {
+		t.Fatalf("expected errors from env, credentials, metadata: %v", report.Err())
+	}
+	for i, name := range []string{"env", "credentials", "metadata"} {
+		if report.Errors[i].Source != name {
+			t.Errorf("%d: expected source %s: %v", i, name, report.Errors[i])
+		}
+	}
+	if !strings.Contains(report.Errors[0].Error(), ProjectEnvVar) {
+		t.Errorf("env error must mention %s: %v", ProjectEnvVar, report.Errors[0])
+	}
+}
+
+func TestCredentialsProjectIDSource(t *testing.T) {
+	// the credentials source must never use the metadata server, which has no timeout there
+	metadataServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("unexpected metadata request: %s", r.URL)
+		}))
+	defer metadataServer.Close()
+	t.Setenv(MetadataHostEnvVar, strings.TrimPrefix(metadataServer.URL, "http://"))
+	t.Setenv(credentialsEnvVar, "")
+	configDir := t.TempDir()
+	t.Setenv(gcloudConfigDirEnvVar, configDir)
+
+	source := CredentialsProjectIDSource()
+	_, err := source.ProjectID(context.Background())
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ProjectID()=%v; expected the well-known file to not exist", err)
+	}
+
+	// the well-known file written by gcloud auth application-default login
+	err = os.WriteFile(filepath.Join(configDir, "application_default_credentials.json"),
+		[]byte(invalidServiceAccountKey), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectID, err := source.ProjectID(context.Background())
+	if projectID != "bigquery-tools" || err != nil {
+		t.Errorf("ProjectID()=%#v, %v; expected bigquery-tools", projectID, err)
+	}
+}
@@ -0,0 +1,190 @@
+package gcplogs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultStackTraceIdleTimeout is how long StackTraceWriter waits for more lines of a stack trace
+// before writing it.
+const DefaultStackTraceIdleTimeout = 100 * time.Millisecond
+
+// maxStackTraceSize limits the size of a stack trace entry, so a dump of many goroutines stays
+// below Cloud Logging's entry size limit. Later lines are dropped.
+const maxStackTraceSize = 128 * 1024
+
+const stackTraceDropped = "\n...additional lines dropped"
+
+// stackTraceStartPattern matches the first line of a Go panic or fatal error printed by the
+// runtime, or a panic logged by net/http with the standard log flags.
+var stackTraceStartPattern = regexp.MustCompile(
+	`^(panic: |fatal error: |(\d{4}/\d\d/\d\d \d\d:\d\d:\d\d(\.\d+)? )?http: panic serving )`)
+
+// stackTraceLinePattern matches the lines that follow the first line of a stack trace: blank
+// lines, goroutine headers, function calls, indented file names, and the lines the runtime adds.
+var stackTraceLinePattern = regexp.MustCompile(`^($|goroutine \d+ \[|[ \t]|created by |` +
+	`exit status \d+$|\[signal |panic: |\S+\(.*\)$|\.\.\.additional frames elided\.\.\.$)`)
+
+// stackTraceEntry is the JSON entry written for a stack trace.
+type stackTraceEntry struct {
+	Severity string `json:"severity"`
+	Time     string `json:"time"`
+	Message  string `json:"message"`
+}
+
+// StackTraceWriter is an io.Writer for raw text logs, such as the stderr of a child process, that
+// writes each Go panic, fatal error, or "http: panic serving" message and its stack trace as one
+// JSON entry with severity ERROR. Otherwise, Cloud Logging writes each line of a stack trace as a
+// separate entry, and Error Reporting does not find it. Other lines are written unchanged. A stack
+// trace ends at the first line that is not part of it, or when nothing is written for the idle
+// timeout. Use it as the Stderr of an exec.Cmd, or as the output of the log.Logger used as an
+// http.Server's ErrorLog. Call Flush before exiting to write a buffered stack trace.
+type StackTraceWriter struct {
+	out         io.Writer
+	idleTimeout time.Duration
+
+	mu sync.Mutex
+	// partial is the last line written, if it did not end with a newline
+	partial []byte
+	// trace is the stack trace being collected, without the final newline
+	trace     []byte
+	traceTime time.Time
+	dropped   bool
+	lastWrite time.Time
+	timer     *time.Timer
+	// err is an error from writing a stack trace after the idle timeout
+	err error
+}
+
+// NewStackTraceWriter returns a StackTraceWriter that writes to out. If idleTimeout is zero,
+// DefaultStackTraceIdleTimeout is used.
+func NewStackTraceWriter(out io.Writer, idleTimeout time.Duration) *StackTraceWriter {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultStackTraceIdleTimeout
+	}
+	return &StackTraceWriter{out: out, idleTimeout: idleTimeout}
+}
+
+// Write writes the complete lines in p, and buffers stack traces and the last line if it is not
+// complete. It returns an error if writing to the output failed, including for an earlier stack
+// trace written after the idle timeout.
+func (w *StackTraceWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastWrite = time.Now()
+
+	err := w.takeErr()
+	data := p
+	if len(w.partial) > 0 {
+		data = append(w.partial, p...)
+		w.partial = nil
+	}
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			// copy: the caller may reuse p
+			w.partial = append([]byte(nil), data...)
+			break
+		}
+		err = errors.Join(err, w.writeLine(data[:end+1]))
+		data = data[end+1:]
+	}
+
+	if len(w.trace) > 0 || len(w.partial) > 0 {
+		if w.timer == nil {
+			w.timer = time.AfterFunc(w.idleTimeout, w.idle)
+		} else {
+			w.timer.Reset(w.idleTimeout)
+		}
+	}
+	return len(p), err
+}
+
+// Flush writes the buffered stack trace and incomplete line, if any.
+func (w *StackTraceWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	return errors.Join(w.takeErr(), w.flushLocked())
+}
+
+// idle is called by the timer to write a stack trace after the idle timeout.
+func (w *StackTraceWriter) idle() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if time.Since(w.lastWrite) < w.idleTimeout {
+		// Write reset the timer while this was waiting for the lock
+		return
+	}
+	w.err = errors.Join(w.err, w.flushLocked())
+}
+
+func (w *StackTraceWriter) flushLocked() error {
+	var err error
+	if len(w.partial) > 0 {
+		err = w.writeLine(w.partial)
+		w.partial = nil
+	}
+	return errors.Join(err, w.writeTrace())
+}
+
+func (w *StackTraceWriter) takeErr() error {
+	err := w.err
+	w.err = nil
+	return err
+}
+
+// writeLine adds line to the stack trace if it is part of it, otherwise it writes the stack trace,
+// then writes line or starts a new stack trace.
+func (w *StackTraceWriter) writeLine(line []byte) error {
+	text := bytes.TrimRight(line, "\r\n")
+	if len(w.trace) > 0 && stackTraceLinePattern.Match(text) {
+		if len(w.trace)+1+len(text) > maxStackTraceSize {
+			w.dropped = true
+		} else if !w.dropped {
+			w.trace = append(w.trace, '\n')
+			w.trace = append(w.trace, text...)
+		}
+		return nil
+	}
+
+	err := w.writeTrace()
+	if stackTraceStartPattern.Match(text) {
+		w.trace = append(w.trace[:0], text...)
+		w.traceTime = time.Now()
+		return err
+	}
+	_, writeErr := w.out.Write(line)
+	return errors.Join(err, writeErr)
+}
+
+// writeTrace writes the buffered stack trace as a JSON entry, if there is one.
+func (w *StackTraceWriter) writeTrace() error {
+	if len(w.trace) == 0 {
+		return nil
+	}
+	message := string(bytes.TrimRight(w.trace, "\n"))
+	if w.dropped {
+		message += stackTraceDropped
+	}
+	w.trace = w.trace[:0]
+	w.dropped = false
+
+	entry, err := json.Marshal(stackTraceEntry{
+		Severity: "ERROR",
+		Time:     w.traceTime.UTC().Format(time.RFC3339Nano),
+		Message:  message,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(append(entry, '\n'))
+	return err
+}
@@ -0,0 +1,159 @@
+package gcplogs
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testDefaultPanic = `panic: not a real panic (default)
+
+goroutine 1 [running]:
+main.panicNormally(...)
+	/gopath/src/github.com/evanj/gcplogs/appengine/logdemo.go:28
+main.funcWithArgs(0x1)
+	/gopath/src/github.com/evanj/gcplogs/appengine/logdemo.go:32 +0x39
+main.main()
+	/gopath/src/github.com/evanj/gcplogs/appengine/logdemo.go:45 +0xb1
+exit status 2
+`
+
+const testHTTPPanic = `2019/02/23 07:25:58 http: panic serving [::1]:62811: not a real panic (http)
+goroutine 37 [running]:
+net/http.(*conn).serve.func1(0xc00013a1e0)
+  /go/src/net/http/server.go:1746 +0xd0
+panic(0x1246000, 0x12ebcd0)
+  /go/src/runtime/panic.go:513 +0x1b9
+created by net/http.(*Server).Serve
+  /go/src/net/http/server.go:2851 +0x2f5
+`
+
+// syncBuffer is a bytes.Buffer that can be written by the idle timer while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// checkStackTraceEntry checks that line is an ERROR entry with message.
+func checkStackTraceEntry(t *testing.T, line string, message string) {
+	t.Helper()
+	var entry stackTraceEntry
+	err := json.Unmarshal([]byte(line), &entry)
+	if err != nil {
+		t.Fatalf("line must be a JSON entry: %#v: %s", line, err)
+	}
+	if entry.Severity != "ERROR" || entry.Message != message || entry.Time == "" {
+		t.Errorf("entry=%#v; expected ERROR with message %#v", entry, message)
+	}
+}
+
+func TestStackTraceWriter(t *testing.T) {
+	input := "before\n" + testDefaultPanic + "after 1\n" + testHTTPPanic + testHTTPPanic +
+		"after 2\n"
+
+	// write everything at once, and one byte at a time
+	for _, chunkSize := range []int{len(input), 1} {
+		out := &syncBuffer{}
+		w := NewStackTraceWriter(out, time.Hour)
+		for i := 0; i < len(input); i += chunkSize {
+			end := min(i+chunkSize, len(input))
+			n, err := w.Write([]byte(input[i:end]))
+			if n != end-i || err != nil {
+				t.Fatalf("Write()=%d, %v", n, err)
+			}
+		}
+		err := w.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if len(lines) != 6 {
+			t.Fatalf("chunkSize=%d: expected 6 lines: %#v", chunkSize, lines)
+		}
+		if lines[0] != "before" || lines[2] != "after 1" || lines[5] != "after 2" {
+			t.Errorf("chunkSize=%d: other lines must be unchanged: %#v", chunkSize, lines)
+		}
+		checkStackTraceEntry(t, lines[1], strings.TrimSuffix(testDefaultPanic, "\n"))
+		checkStackTraceEntry(t, lines[3], strings.TrimSuffix(testHTTPPanic, "\n"))
+		checkStackTraceEntry(t, lines[4], strings.TrimSuffix(testHTTPPanic, "\n"))
+	}
+}
+
+func TestStackTraceWriterIdle(t *testing.T) {
+	out := &syncBuffer{}
+	w := NewStackTraceWriter(out, time.Millisecond)
+	_, err := w.Write([]byte("fatal error: all goroutines are asleep - deadlock!\n\ngoroutine 1 ["))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "" {
+		t.Errorf("the stack trace must be buffered: %#v", out.String())
+	}
+
+	for start := time.Now(); out.String() == "" && time.Since(start) < 10*time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one entry after the idle timeout: %#v", lines)
+	}
+	checkStackTraceEntry(t, lines[0],
+		"fatal error: all goroutines are asleep - deadlock!\n\ngoroutine 1 [")
+
+	// incomplete lines are also written after the idle timeout
+	_, err = w.Write([]byte("partial"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); !strings.HasSuffix(out.String(), "partial") &&
+		time.Since(start) < 10*time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	if !strings.HasSuffix(out.String(), "}\npartial") {
+		t.Errorf("the incomplete line must be written unchanged: %#v", out.String())
+	}
+}
+
+func TestStackTraceWriterMaxSize(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := NewStackTraceWriter(out, time.Hour)
+	frame := "main.f()\n\t/src/main.go:10 +0x39\n"
+	input := "panic: big\n\ngoroutine 1 [running]:\n" +
+		strings.Repeat(frame, maxStackTraceSize/len(frame)+1) + "after\n"
+	_, err := w.Write([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, after, _ := strings.Cut(out.String(), "\n")
+	var entry stackTraceEntry
+	err = json.Unmarshal([]byte(line), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Message) > maxStackTraceSize+len(stackTraceDropped) {
+		t.Errorf("message is %d bytes; expected at most %d", len(entry.Message), maxStackTraceSize)
+	}
+	if !strings.HasSuffix(entry.Message, "\nmain.f()"+stackTraceDropped) {
+		t.Errorf("message must say lines were dropped: %#v", entry.Message[len(entry.Message)-100:])
+	}
+	if after != "after\n" {
+		t.Errorf("the line after the stack trace must be written: %#v", after)
+	}
+}
@@ -0,0 +1,81 @@
+package gcplogs
+
+// W3C traceparent header: VERSION-TRACE_ID-PARENT_ID-FLAGS. See:
+// https://www.w3.org/TR/trace-context/#traceparent-header-field-values
+const traceParentLength = 2 + 1 + 32 + 1 + 16 + 1 + 2
+
+// The sampled bit in the traceparent flags.
+const traceParentSampled = 0x01
+
+// traceParent contains the fields of a valid traceparent header.
+type traceParent struct {
+	traceID  string
+	parentID string
+	flags    byte
+}
+
+// parseTraceParent parses a traceparent header value. It returns false if the value is invalid
+// according to the W3C specification.
+func parseTraceParent(headerValue string) (traceParent, bool) {
+	if len(headerValue) < traceParentLength {
+		return traceParent{}, false
+	}
+
+	version, ok := parseHexByte(headerValue[0:2])
+	// version ff is forbidden
+	if !ok || version == 0xff {
+		return traceParent{}, false
+	}
+	// version 00 must be exactly this length; future versions can append fields after a dash
+	if len(headerValue) > traceParentLength &&
+		(version == 0 || headerValue[traceParentLength] != '-') {
+		return traceParent{}, false
+	}
+	if headerValue[2] != '-' || headerValue[35] != '-' || headerValue[52] != '-' {
+		return traceParent{}, false
+	}
+
+	traceID := headerValue[3:35]
+	parentID := headerValue[36:52]
+	if !isLowerHex(traceID) || isAllZeros(traceID) || !isLowerHex(parentID) || isAllZeros(parentID) {
+		return traceParent{}, false
+	}
+	flags, ok := parseHexByte(headerValue[53:55])
+	if !ok {
+		return traceParent{}, false
+	}
+	return traceParent{traceID, parentID, flags}, true
+}
+
+// Returns true if s only contains the characters [0-9a-f]. Upper case is not permitted.
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !(('0' <= s[i] && s[i] <= '9') || ('a' <= s[i] && s[i] <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAllZeros(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHexByte(s string) (byte, bool) {
+	if len(s) != 2 || !isLowerHex(s) {
+		return 0, false
+	}
+	return hexValue(s[0])<<4 | hexValue(s[1]), true
+}
+
+func hexValue(c byte) byte {
+	if c <= '9' {
+		return c - '0'
+	}
+	return c - 'a' + 10
+}
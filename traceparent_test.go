@@ -0,0 +1,45 @@
+package gcplogs
+
+import "testing"
+
+func TestParseTraceParent(t *testing.T) {
+	const validTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const validParentID = "00f067aa0ba902b7"
+
+	tests := []struct {
+		input    string
+		expected traceParent
+		ok       bool
+	}{
+		{"", traceParent{}, false},
+		{"invalid", traceParent{}, false},
+		{"00-" + validTraceID + "-" + validParentID + "-01",
+			traceParent{validTraceID, validParentID, 1}, true},
+		{"00-" + validTraceID + "-" + validParentID + "-00",
+			traceParent{validTraceID, validParentID, 0}, true},
+		// future versions may add fields after a dash
+		{"01-" + validTraceID + "-" + validParentID + "-09-extra",
+			traceParent{validTraceID, validParentID, 9}, true},
+		// version 00 must not have extra fields
+		{"00-" + validTraceID + "-" + validParentID + "-01-extra", traceParent{}, false},
+		{"01-" + validTraceID + "-" + validParentID + "-01extra", traceParent{}, false},
+		// forbidden version
+		{"ff-" + validTraceID + "-" + validParentID + "-01", traceParent{}, false},
+		{"0x-" + validTraceID + "-" + validParentID + "-01", traceParent{}, false},
+		// all zero IDs are invalid
+		{"00-00000000000000000000000000000000-" + validParentID + "-01", traceParent{}, false},
+		{"00-" + validTraceID + "-0000000000000000-01", traceParent{}, false},
+		// upper case is not permitted
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-" + validParentID + "-01", traceParent{}, false},
+		{"00-" + validTraceID + "-" + validParentID + "-0G", traceParent{}, false},
+		{"00_" + validTraceID + "_" + validParentID + "_01", traceParent{}, false},
+	}
+
+	for i, test := range tests {
+		output, ok := parseTraceParent(test.input)
+		if output != test.expected || ok != test.ok {
+			t.Errorf("%d: parseTraceParent(%#v)=%#v, %t; expected %#v, %t",
+				i, test.input, output, ok, test.expected, test.ok)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package gcplogs
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport is an http.RoundTripper that propagates the trace from each request's context, stored
+// with ContextWithTrace, to the server it calls. It sets the X-Cloud-Trace-Context and traceparent
+// headers with a new child span ID. Requests without a trace, or that already have one of these
+// headers, are sent unchanged.
+type Transport struct {
+	// Base sends the requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// Observe is called after each request completes, if it is not nil. r is the request that was
+	// sent, and its context contains the child span. The gcpzap package uses this to log requests.
+	Observe func(r *http.Request, resp *http.Response, err error, latency time.Duration)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	parent := TraceFromContext(r.Context())
+	if parent.Trace != "" && r.Header.Get(TraceHeader) == "" && r.Header.Get(TraceParentHeader) == "" {
+		child := parent.NewChildSpan()
+		// RoundTrip must not modify the request
+		r = r.Clone(ContextWithTrace(r.Context(), child))
+		cloudTraceContext, traceParent := child.HeaderValues()
+		if cloudTraceContext != "" {
+			r.Header.Set(TraceHeader, cloudTraceContext)
+		}
+		if traceParent != "" {
+			r.Header.Set(TraceParentHeader, traceParent)
+		}
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(r)
+	if t.Observe != nil {
+		t.Observe(r, resp, err, time.Since(start))
+	}
+	return resp, err
+}
+
+// TraceID returns the trace ID without the projects/PROJECT_ID/traces/ prefix.
+func (tc TraceContext) TraceID() string {
+	return tc.Trace[strings.LastIndexByte(tc.Trace, '/')+1:]
+}
+
+// NewChildSpan returns a TraceContext in the same trace with a new random span ID. It is used to
+// propagate the trace to another service.
+func (tc TraceContext) NewChildSpan() TraceContext {
+	spanID := uint64(0)
+	for spanID == 0 {
+		spanID = rand.Uint64()
+	}
+	tc.SpanID = fmt.Sprintf("%016x", spanID)
+	return tc
+}
+
+// HeaderValues returns the X-Cloud-Trace-Context and W3C traceparent header values for this trace
+// and span. traceParent is empty if the trace ID is not in the W3C format, and both are empty if
+// there is no trace or span ID. The X-Cloud-Trace-Context option is omitted if the sampling
+// decision is not known.
+func (tc TraceContext) HeaderValues() (cloudTraceContext string, traceParent string) {
+	traceID := tc.TraceID()
+	spanID, err := strconv.ParseUint(tc.SpanID, 16, 64)
+	if traceID == "" || err != nil {
+		return "", ""
+	}
+
+	cloudTraceContext = traceID + "/" + strconv.FormatUint(spanID, 10)
+	flags := "00"
+	if tc.Sampled {
+		cloudTraceContext += ";o=1"
+		flags = "01"
+	} else if tc.SampledKnown {
+		cloudTraceContext += ";o=0"
+	}
+
+	if len(traceID) == 32 && isLowerHex(traceID) && !isAllZeros(traceID) {
+		traceParent = "00-" + traceID + "-" + tc.SpanID + "-" + flags
+	}
+	return cloudTraceContext, traceParent
+}
@@ -0,0 +1,107 @@
+package gcplogs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	var serverHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverHeaders = r.Header.Clone()
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	var observedRequest *http.Request
+	var observedStatus int
+	transport := &Transport{Observe: func(r *http.Request, resp *http.Response, err error, latency time.Duration) {
+		if err != nil {
+			t.Error(err)
+		}
+		if latency <= 0 {
+			t.Error("latency must be positive:", latency)
+		}
+		observedRequest = r
+		observedStatus = resp.StatusCode
+	}}
+	client := &http.Client{Transport: transport}
+
+	// without a trace: no headers
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if serverHeaders.Get(TraceHeader) != "" || serverHeaders.Get(TraceParentHeader) != "" {
+		t.Errorf("request must not have trace headers: %#v", serverHeaders)
+	}
+	if observedStatus != http.StatusTeapot {
+		t.Errorf("Observe must be called with the response; status=%d", observedStatus)
+	}
+
+	// with a trace: propagates it with a new span
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parent := TraceContext{"projects/p/traces/" + traceID, "00f067aa0ba902b7", true, true}
+	ctx := ContextWithTrace(context.Background(), parent)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if req.Header.Get(TraceHeader) != "" {
+		t.Error("RoundTrip must not modify the request")
+	}
+	child := TraceFromContext(observedRequest.Context())
+	if child.Trace != parent.Trace || !child.Sampled || child.SpanID == parent.SpanID || len(child.SpanID) != 16 {
+		t.Errorf("invalid child span: %#v", child)
+	}
+	expectedTraceParent := "00-" + traceID + "-" + child.SpanID + "-01"
+	if serverHeaders.Get(TraceParentHeader) != expectedTraceParent {
+		t.Errorf("traceparent=%#v; expected %#v", serverHeaders.Get(TraceParentHeader), expectedTraceParent)
+	}
+
+	// the server parses the same trace and span from both headers
+	tracer := &Tracer{ProjectID: "p"}
+	serverReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	serverReq.Header.Set(TraceHeader, serverHeaders.Get(TraceHeader))
+	if !strings.HasPrefix(serverHeaders.Get(TraceHeader), traceID+"/") {
+		t.Errorf("wrong %s: %#v", TraceHeader, serverHeaders.Get(TraceHeader))
+	}
+	if tracer.TraceContextFromRequest(serverReq) != child {
+		t.Errorf("server parsed %#v; expected %#v", tracer.TraceContextFromRequest(serverReq), child)
+	}
+}
+
+func TestHeaderValues(t *testing.T) {
+	tests := []struct {
+		input             TraceContext
+		cloudTraceContext string
+		traceParent       string
+	}{
+		{TraceContext{}, "", ""},
+		{TraceContext{"projects/p/traces/traceid", "", false, true}, "", ""},
+		{TraceContext{"projects/p/traces/traceid", "000000000000007b", false, true}, "traceid/123;o=0", ""},
+		{TraceContext{"projects/p/traces/traceid", "000000000000007b", false, false}, "traceid/123", ""},
+		{TraceContext{"projects/p/traces/4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true, true},
+			"4bf92f3577b34da6a3ce929d0e0e4736/67667974448284343;o=1",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}
+
+	for i, test := range tests {
+		cloudTraceContext, traceParent := test.input.HeaderValues()
+		if cloudTraceContext != test.cloudTraceContext || traceParent != test.traceParent {
+			t.Errorf("%d: %#v.HeaderValues()=%#v, %#v; expected %#v, %#v", i, test.input,
+				cloudTraceContext, traceParent, test.cloudTraceContext, test.traceParent)
+		}
+	}
+}
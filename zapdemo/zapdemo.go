@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -19,14 +20,10 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(rootHTML))
 }
 
-type server struct {
-	tracer gcpzap.Tracer
-}
-
-func (s *server) logDemo(w http.ResponseWriter, r *http.Request) {
+func logDemo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain;charset=utf-8")
 
-	reqLogger := s.tracer.FromRequest(r)
+	reqLogger := gcpzap.FromContext(r.Context())
 	fmt.Fprintf(w, "wrote some logs:\n")
 
 	reqLogger.Debug("debug message", zap.Int("example_key", 100))
@@ -35,23 +32,24 @@ func (s *server) logDemo(w http.ResponseWriter, r *http.Request) {
 	reqLogger.Error("error message", zap.Int("example_key", 103))
 }
 
-func (s *server) fatalDemo(w http.ResponseWriter, r *http.Request) {
+func fatalDemo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain;charset=utf-8")
 	fmt.Fprintf(w, "writing fatal level\n")
 
-	s.tracer.FromRequest(r).Fatal("fatal message")
+	gcpzap.FromContext(r.Context()).Fatal("fatal message")
 }
 
 func main() {
-	logger, err := gcpzap.NewProduction()
+	logger, err := gcpzap.NewAuto()
 	if err != nil {
 		panic(err)
 	}
 	defer logger.Sync()
 
-	projectID := gcplogs.DefaultProjectID()
+	projectID, report := (&gcplogs.ProjectIDResolver{}).Resolve(context.Background())
 	if projectID == "" {
 		fmt.Fprintln(os.Stderr, "Could not find Google Project ID; Set "+gcplogs.ProjectEnvVar)
+		fmt.Fprintln(os.Stderr, report.Err())
 		os.Exit(1)
 	}
 
@@ -61,13 +59,16 @@ func main() {
 	}
 	listenAddr := ":" + port
 
-	logger.Info("zapdemo starting ...", zap.String("projectID", projectID), zap.String("addr", listenAddr))
+	logger.Info("zapdemo starting ...", zap.String("projectID", projectID),
+		zap.String("projectIDSource", report.Source), zap.String("addr", listenAddr))
 
-	s := &server{gcpzap.Tracer{Tracer: gcplogs.Tracer{ProjectID: projectID}, Logger: logger}}
-	http.HandleFunc("/", rootHandler)
-	http.HandleFunc("/log_demo", s.logDemo)
-	http.HandleFunc("/fatal", s.fatalDemo)
-	err = http.ListenAndServe(listenAddr, nil)
+	zap.ReplaceGlobals(logger)
+	tracer := &gcpzap.Tracer{Tracer: gcplogs.Tracer{ProjectID: projectID}, Logger: logger}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", rootHandler)
+	mux.HandleFunc("/log_demo", logDemo)
+	mux.HandleFunc("/fatal", fatalDemo)
+	err = http.ListenAndServe(listenAddr, tracer.Middleware(mux))
 	if err != nil {
 		panic(err)
 	}